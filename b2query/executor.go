@@ -0,0 +1,521 @@
+package b2query
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	schema "github.com/babydb/babydb/b2schema"
+)
+
+// TimeField 查询语句中表示表时间轴字段的名称
+const TimeField = "time"
+
+// Result 查询结果
+type Result struct {
+	// Columns 结果列名
+	Columns []string
+	// Rows 结果行，每行的值与Columns一一对应
+	Rows [][]interface{}
+}
+
+// Execute 解析并执行一条查询语句
+func Execute(db *schema.B2Database, meta *schema.MetaDBSource, query string) (*Result, error) {
+	stmt, err := Parse(query)
+	if err != nil {
+		log.Printf("解析查询语句时发生错误: %v\n", err)
+		return nil, err
+	}
+	return ExecuteStatement(db, meta, stmt)
+}
+
+// ExecuteStatement 执行一条已经解析好的查询语句，stmt不会被修改，可以重复执行
+func ExecuteStatement(db *schema.B2Database, meta *schema.MetaDBSource, stmt *Statement) (*Result, error) {
+	table, err := db.GetTable(stmt.Table, meta)
+	if err != nil {
+		log.Printf("找不到查询语句中的表 %s: %v\n", stmt.Table, err)
+		return nil, err
+	}
+	// 字段名称解析和常量转换都在副本上进行
+	local := *stmt
+	local.Fields = append([]Field(nil), stmt.Fields...)
+	local.Where = cloneExpr(stmt.Where)
+	q := &execution{stmt: &local, table: table}
+	if err = q.prepare(); err != nil {
+		return nil, err
+	}
+	if q.aggregate {
+		return q.execAggregate(db)
+	}
+	return q.execRaw(db)
+}
+
+// cloneExpr 复制条件表达式树
+func cloneExpr(e Expr) Expr {
+	switch x := e.(type) {
+	case *LogicalExpr:
+		return &LogicalExpr{Op: x.Op, LHS: cloneExpr(x.LHS), RHS: cloneExpr(x.RHS)}
+	case *Comparison:
+		c := *x
+		return &c
+	}
+	return e
+}
+
+// execution 一次查询执行的上下文
+type execution struct {
+	stmt      *Statement
+	table     *schema.B2Table
+	aggregate bool
+	// columns 表中的字段名称集合
+	columns map[string]bool
+	start   time.Time
+	end     time.Time
+}
+
+// prepare 解析字段名称、检查语句与表结构是否相符，并从WHERE中提取时间区间
+func (q *execution) prepare() error {
+	q.columns = make(map[string]bool, len(q.table.Columns))
+	for _, col := range q.table.Columns {
+		q.columns[col.ColumnName] = true
+	}
+	for i, f := range q.stmt.Fields {
+		if len(f.Func) > 0 {
+			q.aggregate = true
+		}
+		if f.Column == "*" {
+			continue
+		}
+		name, err := q.resolve(f.Column)
+		if err != nil {
+			return err
+		}
+		q.stmt.Fields[i].Column = name
+		if len(f.Alias) == 0 && name != f.Column {
+			q.stmt.Fields[i].Alias = f.Column
+		}
+	}
+	if q.aggregate {
+		for _, f := range q.stmt.Fields {
+			if len(f.Func) == 0 {
+				return errors.New("cannot mix aggregate and raw fields")
+			}
+			// count不需要数值，其余聚合函数只能用于数值字段
+			if f.Func != "count" && !q.numeric(f.Column) {
+				return fmt.Errorf("%s(%s) requires a numeric column", f.Func, f.Column)
+			}
+		}
+	} else if q.stmt.GroupByTime > 0 {
+		return errors.New("GROUP BY time requires aggregate fields")
+	}
+	if q.stmt.GroupByTime > 0 && len(q.table.TimeColumn) == 0 {
+		return errors.New("GROUP BY time requires a table with a time column")
+	}
+	if len(q.stmt.OrderBy) > 0 {
+		name, err := q.resolve(q.stmt.OrderBy)
+		if err != nil {
+			return err
+		}
+		if q.aggregate && name != q.table.TimeColumn {
+			return errors.New("aggregate queries can only be ordered by time")
+		}
+		q.stmt.OrderBy = name
+	}
+	if err := q.prepareExpr(q.stmt.Where); err != nil {
+		return err
+	}
+	q.start, q.end = time.Unix(0, math.MinInt64), time.Unix(0, math.MaxInt64)
+	if len(q.table.TimeColumn) > 0 {
+		q.narrowTimeRange(q.stmt.Where)
+	}
+	return nil
+}
+
+// numeric 字段是否为数值类型
+func (q *execution) numeric(name string) bool {
+	for i := range q.table.Columns {
+		if q.table.Columns[i].ColumnName == name {
+			return q.table.Columns[i].IsNumeric()
+		}
+	}
+	return false
+}
+
+// resolve 将查询中的字段名称解析为表中的字段名称，time表示时间轴字段
+func (q *execution) resolve(name string) (string, error) {
+	if q.columns[name] {
+		return name, nil
+	}
+	if strings.EqualFold(name, TimeField) && len(q.table.TimeColumn) > 0 {
+		return q.table.TimeColumn, nil
+	}
+	return "", fmt.Errorf("no such column %s in table %s", name, q.table.TableName)
+}
+
+// prepareExpr 解析条件中的字段名称，并把与时间轴字段比较的常量转换为time.Time
+func (q *execution) prepareExpr(e Expr) error {
+	switch x := e.(type) {
+	case nil:
+		return nil
+	case *LogicalExpr:
+		if err := q.prepareExpr(x.LHS); err != nil {
+			return err
+		}
+		return q.prepareExpr(x.RHS)
+	case *Comparison:
+		name, err := q.resolve(x.Column)
+		if err != nil {
+			return err
+		}
+		x.Column = name
+		if name != q.table.TimeColumn {
+			return nil
+		}
+		switch v := x.Value.(type) {
+		case int64:
+			// 整数按时间轴字段声明的精度解释为Unix时间
+			t, err := q.epochTime(v)
+			if err != nil {
+				return err
+			}
+			x.Value = t
+		case string:
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return fmt.Errorf("invalid time %q, RFC3339 expected", v)
+			}
+			x.Value = t
+		case time.Time:
+		default:
+			return fmt.Errorf("invalid time value %v", v)
+		}
+	}
+	return nil
+}
+
+// epochTime 按时间轴字段的精度把Unix时间整数转换为time.Time
+func (q *execution) epochTime(v int64) (time.Time, error) {
+	for _, col := range q.table.Columns {
+		if col.ColumnName != q.table.TimeColumn {
+			continue
+		}
+		bs, err := col.FormatBytes(v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time value %d", v)
+		}
+		return schema.BytesToTime(bs), nil
+	}
+	return time.Unix(0, v), nil
+}
+
+// narrowTimeRange 用AND连接的时间轴字段比较条件缩小扫描的时间区间 [start, end)
+func (q *execution) narrowTimeRange(e Expr) {
+	switch x := e.(type) {
+	case *LogicalExpr:
+		if x.Op == "and" {
+			q.narrowTimeRange(x.LHS)
+			q.narrowTimeRange(x.RHS)
+		}
+	case *Comparison:
+		t, ok := x.Value.(time.Time)
+		if !ok || x.Column != q.table.TimeColumn {
+			return
+		}
+		lower, upper := q.start, q.end
+		switch x.Op {
+		case "=":
+			lower, upper = t, t.Add(time.Nanosecond)
+		case ">":
+			lower = t.Add(time.Nanosecond)
+		case ">=":
+			lower = t
+		case "<":
+			upper = t
+		case "<=":
+			upper = t.Add(time.Nanosecond)
+		}
+		if lower.After(q.start) {
+			q.start = lower
+		}
+		if upper.Before(q.end) {
+			q.end = upper
+		}
+	}
+}
+
+// match 判断一行数据是否满足条件
+func (q *execution) match(e Expr, row schema.Row) bool {
+	switch x := e.(type) {
+	case nil:
+		return true
+	case *LogicalExpr:
+		if x.Op == "and" {
+			return q.match(x.LHS, row) && q.match(x.RHS, row)
+		}
+		return q.match(x.LHS, row) || q.match(x.RHS, row)
+	case *Comparison:
+		c, ok := compareValues(row.Values[x.Column], x.Value)
+		if !ok {
+			return false
+		}
+		switch x.Op {
+		case "=":
+			return c == 0
+		case "!=":
+			return c != 0
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		case ">=":
+			return c >= 0
+		}
+	}
+	return false
+}
+
+// execRaw 执行不带聚合的查询
+func (q *execution) execRaw(db *schema.B2Database) (*Result, error) {
+	var names []string
+	for _, f := range q.stmt.Fields {
+		if f.Column == "*" {
+			for _, col := range q.table.Columns {
+				names = append(names, col.ColumnName)
+			}
+			continue
+		}
+		names = append(names, f.Column)
+	}
+	var rows []schema.Row
+	collect := func(row schema.Row) bool {
+		if q.match(q.stmt.Where, row) {
+			rows = append(rows, row)
+		}
+		return true
+	}
+	var err error
+	orderBy := q.stmt.OrderBy
+	if len(q.table.TimeColumn) > 0 {
+		err = q.table.QueryTimeRangeFunc(db, q.start, q.end, nil, collect)
+		if len(orderBy) == 0 {
+			orderBy = q.table.TimeColumn
+		}
+	} else {
+		err = q.table.ScanFunc(db, schema.ScanOptions{}, collect)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(orderBy) > 0 {
+		sort.SliceStable(rows, func(i, j int) bool {
+			a, b := rows[i].Values[orderBy], rows[j].Values[orderBy]
+			if q.stmt.Desc {
+				return lessValue(b, a)
+			}
+			return lessValue(a, b)
+		})
+	}
+	if q.stmt.Limit > 0 && len(rows) > q.stmt.Limit {
+		rows = rows[:q.stmt.Limit]
+	}
+	res := &Result{Columns: q.rawColumnNames()}
+	for _, row := range rows {
+		values := make([]interface{}, len(names))
+		for i, name := range names {
+			values[i] = row.Values[name]
+		}
+		res.Rows = append(res.Rows, values)
+	}
+	return res, nil
+}
+
+// rawColumnNames 不带聚合的查询结果列名，* 展开为表中全部字段
+func (q *execution) rawColumnNames() []string {
+	var names []string
+	for _, f := range q.stmt.Fields {
+		if f.Column == "*" {
+			for _, col := range q.table.Columns {
+				names = append(names, col.ColumnName)
+			}
+			continue
+		}
+		names = append(names, f.Name())
+	}
+	return names
+}
+
+// execAggregate 执行带聚合函数的查询，结果第一列为时间桶起始时间
+func (q *execution) execAggregate(db *schema.B2Database) (*Result, error) {
+	var columns []string
+	seen := make(map[string]bool)
+	for _, f := range q.stmt.Fields {
+		if f.Column != "*" && !seen[f.Column] {
+			seen[f.Column] = true
+			columns = append(columns, f.Column)
+		}
+	}
+	opts := schema.AggregateOptions{
+		Start:    q.start,
+		End:      q.end,
+		Interval: q.stmt.GroupByTime,
+		Columns:  columns,
+	}
+	if q.stmt.Where != nil {
+		opts.Filter = func(row schema.Row) bool {
+			return q.match(q.stmt.Where, row)
+		}
+	}
+	buckets, err := q.table.Aggregate(db, opts)
+	if err != nil {
+		return nil, err
+	}
+	if q.stmt.Desc {
+		for i, j := 0, len(buckets)-1; i < j; i, j = i+1, j-1 {
+			buckets[i], buckets[j] = buckets[j], buckets[i]
+		}
+	}
+	if q.stmt.Limit > 0 && len(buckets) > q.stmt.Limit {
+		buckets = buckets[:q.stmt.Limit]
+	}
+	res := &Result{Columns: []string{TimeField}}
+	for _, f := range q.stmt.Fields {
+		res.Columns = append(res.Columns, f.Name())
+	}
+	for _, b := range buckets {
+		values := []interface{}{b.Start}
+		for _, f := range q.stmt.Fields {
+			values = append(values, aggregateValue(f, b))
+		}
+		res.Rows = append(res.Rows, values)
+	}
+	return res, nil
+}
+
+// aggregateValue 取出时间桶中某个聚合字段的值，没有数据时为nil
+func aggregateValue(f Field, b schema.Bucket) interface{} {
+	if f.Column == "*" {
+		return b.Count
+	}
+	agg, ok := b.Values[f.Column]
+	if !ok {
+		return nil
+	}
+	switch f.Func {
+	case "count":
+		return agg.Count
+	case "sum":
+		return agg.Sum
+	case "min":
+		return agg.Min
+	case "max":
+		return agg.Max
+	case "mean":
+		return agg.Mean
+	case "first":
+		return agg.First
+	case "last":
+		return agg.Last
+	}
+	return nil
+}
+
+// compareValues 比较字段值a与常量b，类型不可比较时第二个返回值为false
+func compareValues(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	if t, ok := b.(time.Time); ok {
+		switch v := a.(type) {
+		case time.Time:
+			return compareInt64(v.UnixNano(), t.UnixNano()), true
+		case int64:
+			return compareInt64(v, t.UnixNano()), true
+		}
+		return 0, false
+	}
+	if ai, ok := integerValue(a); ok {
+		if bi, ok := integerValue(b); ok {
+			return compareInt64(ai, bi), true
+		}
+	}
+	if af, ok := floatValue(a); ok {
+		if bf, ok := floatValue(b); ok {
+			switch {
+			case af < bf:
+				return -1, true
+			case af > bf:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	as, aok := stringValue(a)
+	bs, bok := stringValue(b)
+	if aok && bok {
+		return bytes.Compare(as, bs), true
+	}
+	return 0, false
+}
+
+// lessValue 排序用的比较，nil排在最前
+func lessValue(a, b interface{}) bool {
+	if a == nil {
+		return b != nil
+	}
+	if b == nil {
+		return false
+	}
+	c, _ := compareValues(a, b)
+	return c < 0
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func integerValue(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
+
+func floatValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func stringValue(v interface{}) ([]byte, bool) {
+	switch s := v.(type) {
+	case string:
+		return []byte(s), true
+	case []byte:
+		return s, true
+	}
+	return nil, false
+}
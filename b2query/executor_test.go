@@ -0,0 +1,158 @@
+package b2query
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	schema "github.com/babydb/babydb/b2schema"
+)
+
+var meta *schema.MetaDBSource
+var db *schema.B2Database
+
+func TestMain(t *testing.M) {
+	meta = schema.OpenMetaConn(nil)
+	var err error
+	db, err = schema.NewDatabaseAndOpen("queryDB", meta)
+	if err != nil {
+		panic(err)
+	}
+	cols := make([]schema.B2Column, 3)
+	cols[0] = *schema.NewColumn("ts", "timestamp")
+	cols[1] = *schema.NewColumn("host", "string").Length(32)
+	cols[2] = *schema.NewColumn("temperature", "float64")
+	table, err := schema.NewTimeSeriesTable("cpu", cols, "ts", "host", db, meta)
+	if err != nil {
+		panic(err)
+	}
+	base := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for minute := 0; minute < 6; minute++ {
+		host := []string{"web1", "web2"}[minute%2]
+		ts := base.Add(time.Duration(minute) * time.Minute).UnixNano()
+		if _, err = table.InsertByValues(db, ts, host, float64(minute)); err != nil {
+			panic(err)
+		}
+	}
+	ret := t.Run()
+	db.Close()
+	_ = schema.DropDatabase("queryDB", meta)
+	meta.Close()
+	os.Exit(ret)
+}
+
+func TestExecuteRaw(t *testing.T) {
+	res, err := Execute(db, meta, `SELECT time, temperature FROM cpu
+		WHERE host = 'web1' AND time >= '2019-01-01T00:01:00Z' ORDER BY temperature DESC LIMIT 2`)
+	if err != nil {
+		t.Fatalf("executing query failed: %v", err)
+	}
+	if len(res.Columns) != 2 || res.Columns[0] != "time" || res.Columns[1] != "temperature" {
+		t.Errorf("unexpected columns: %v", res.Columns)
+	}
+	if len(res.Rows) != 2 || res.Rows[0][1] != 4.0 || res.Rows[1][1] != 2.0 {
+		t.Errorf("unexpected rows: %v", res.Rows)
+	}
+}
+
+func TestExecuteAggregate(t *testing.T) {
+	res, err := Execute(db, meta, `SELECT count(*), max(temperature) FROM cpu
+		WHERE time < '2019-01-01T00:05:00Z' GROUP BY time(2m)`)
+	if err != nil {
+		t.Fatalf("executing query failed: %v", err)
+	}
+	if len(res.Rows) != 3 {
+		t.Fatalf("unexpected rows: %v", res.Rows)
+	}
+	last := res.Rows[2]
+	if last[1] != int64(1) || last[2] != 4.0 {
+		t.Errorf("unexpected last bucket: %v", last)
+	}
+	res, err = Execute(db, meta, "SELECT count(host) FROM cpu")
+	if err != nil || len(res.Rows) != 1 || res.Rows[0][1] != int64(6) {
+		t.Errorf("counting a string column failed: %v, %v", res, err)
+	}
+}
+
+func TestCountWithoutNumericColumns(t *testing.T) {
+	cols := make([]schema.B2Column, 2)
+	cols[0] = *schema.NewColumn("ts", "timestamp")
+	cols[1] = *schema.NewColumn("event", "string").Length(32)
+	table, err := schema.NewTimeSeriesTable("events", cols, "ts", "", db, meta)
+	if err != nil {
+		t.Fatalf("creating events failed: %v", err)
+	}
+	base := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, event := range []string{"login", "logout", "login"} {
+		if _, err = table.InsertByValues(db, base.Add(time.Duration(i)*time.Minute), event); err != nil {
+			t.Fatalf("inserting into events failed: %v", err)
+		}
+	}
+	res, err := Execute(db, meta, "SELECT count(*), count(event) FROM events")
+	if err != nil || len(res.Rows) != 1 || res.Rows[0][1] != int64(3) || res.Rows[0][2] != int64(3) {
+		t.Errorf("counting without numeric columns failed: %v, %v", res, err)
+	}
+}
+
+func TestAggregateWithoutTimeColumn(t *testing.T) {
+	cols := make([]schema.B2Column, 2)
+	cols[0] = *schema.NewColumn("name", "string").Length(32)
+	cols[1] = *schema.NewColumn("age", "int32")
+	table, err := schema.NewTable("people", cols, db, meta)
+	if err != nil {
+		t.Fatalf("creating people failed: %v", err)
+	}
+	for _, row := range [][]interface{}{{"alice", int32(30)}, {"bob", nil}, {"carol", int32(40)}} {
+		if _, err = table.InsertByValues(db, row...); err != nil {
+			t.Fatalf("inserting into people failed: %v", err)
+		}
+	}
+	res, err := Execute(db, meta, "SELECT count(*), count(age), max(age) FROM people")
+	if err != nil || len(res.Rows) != 1 || res.Rows[0][1] != int64(3) || res.Rows[0][2] != int64(2) || res.Rows[0][3] != 40.0 {
+		t.Errorf("aggregating a table without time column failed: %v, %v", res, err)
+	}
+	if _, err = Execute(db, meta, "SELECT count(*) FROM people GROUP BY time(1m)"); err == nil {
+		t.Error("GROUP BY time on a table without time column should fail")
+	}
+}
+
+func TestExecuteStatementTwice(t *testing.T) {
+	query := "SELECT time, temperature AS temp FROM cpu WHERE time >= 60000000000 AND host = 'web2' ORDER BY time"
+	stmt, err := Parse(query)
+	if err != nil {
+		t.Fatalf("parsing query failed: %v", err)
+	}
+	first, err := ExecuteStatement(db, meta, stmt)
+	if err != nil {
+		t.Fatalf("executing query failed: %v", err)
+	}
+	second, err := ExecuteStatement(db, meta, stmt)
+	if err != nil {
+		t.Fatalf("executing query again failed: %v", err)
+	}
+	if !reflect.DeepEqual(first, second) || len(first.Rows) != 3 {
+		t.Errorf("results differ between runs: %v, %v", first, second)
+	}
+	fresh, _ := Parse(query)
+	if !reflect.DeepEqual(stmt, fresh) {
+		t.Errorf("statement was modified: %+v", stmt)
+	}
+}
+
+func TestExecuteErrors(t *testing.T) {
+	queries := []string{
+		"SELECT * FROM nosuchtable",
+		"SELECT nosuchcolumn FROM cpu",
+		"SELECT host, count(*) FROM cpu",
+		"SELECT * FROM cpu GROUP BY time(1m)",
+		"SELECT max(temperature) FROM cpu ORDER BY host",
+		"SELECT * FROM cpu WHERE time > 'yesterday'",
+		"SELECT sum(host) FROM cpu",
+	}
+	for _, q := range queries {
+		if _, err := Execute(db, meta, q); err == nil {
+			t.Errorf("expected %q to fail", q)
+		}
+	}
+}
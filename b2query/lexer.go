@@ -0,0 +1,131 @@
+package b2query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// 词法单元类型
+const (
+	tkEOF      = iota // 输入结束
+	tkIdent           // 标识符或关键字
+	tkNumber          // 数字
+	tkDuration        // 时间长度，如 1m、30s
+	tkString          // 单引号字符串
+	tkOp              // 比较运算符与加减号
+	tkComma           // ,
+	tkLParen          // (
+	tkRParen          // )
+	tkStar            // *
+)
+
+// token 词法单元
+type token struct {
+	kind   int
+	text   string
+	pos    int
+	quoted bool // 双引号包围的标识符，不会被当作关键字
+}
+
+// keyword 标识符是否为某个关键字（不区分大小写）
+func (tk token) keyword(kw string) bool {
+	return tk.kind == tkIdent && !tk.quoted && strings.EqualFold(tk.text, kw)
+}
+
+// lex 将查询语句切分为词法单元
+func lex(input string) ([]token, error) {
+	var tokens []token
+	rs := []rune(input)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tkComma, ",", i, false})
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tkLParen, "(", i, false})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tkRParen, ")", i, false})
+			i++
+		case r == '*':
+			tokens = append(tokens, token{tkStar, "*", i, false})
+			i++
+		case r == '+' || r == '-':
+			tokens = append(tokens, token{tkOp, string(r), i, false})
+			i++
+		case r == '=' || r == '<' || r == '>' || r == '!':
+			j := i + 1
+			if j < len(rs) && (rs[j] == '=' || (r == '<' && rs[j] == '>')) {
+				j++
+			}
+			op := string(rs[i:j])
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected character '!' at %d", i)
+			}
+			tokens = append(tokens, token{tkOp, op, i, false})
+			i = j
+		case r == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(rs); j++ {
+				if rs[j] == '\'' {
+					// 两个连续单引号表示一个单引号
+					if j+1 < len(rs) && rs[j+1] == '\'' {
+						sb.WriteRune('\'')
+						j++
+						continue
+					}
+					break
+				}
+				sb.WriteRune(rs[j])
+			}
+			if j >= len(rs) {
+				return nil, errors.New("unterminated string literal")
+			}
+			tokens = append(tokens, token{tkString, sb.String(), i, false})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			j := i
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.' || rs[j] == 'e' || rs[j] == 'E') {
+				j++
+			}
+			kind := tkNumber
+			// 数字后直接跟单位字母的是时间长度
+			if j < len(rs) && unicode.IsLetter(rs[j]) {
+				kind = tkDuration
+				for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j])) {
+					j++
+				}
+			}
+			tokens = append(tokens, token{kind, string(rs[i:j]), i, false})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, token{tkIdent, string(rs[i:j]), i, false})
+			i = j
+		case r == '"':
+			// 双引号包围的标识符
+			j := i + 1
+			for j < len(rs) && rs[j] != '"' {
+				j++
+			}
+			if j >= len(rs) {
+				return nil, errors.New("unterminated quoted identifier")
+			}
+			tokens = append(tokens, token{tkIdent, string(rs[i+1 : j]), i, true})
+			i = j + 1
+		default:
+			return nil, fmt.Errorf("unexpected character %q at %d", r, i)
+		}
+	}
+	tokens = append(tokens, token{tkEOF, "", len(rs), false})
+	return tokens, nil
+}
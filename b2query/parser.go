@@ -0,0 +1,432 @@
+package b2query
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 支持的聚合函数
+var aggregateFuncs = map[string]bool{
+	"count": true,
+	"sum":   true,
+	"min":   true,
+	"max":   true,
+	"mean":  true,
+	"avg":   true,
+	"first": true,
+	"last":  true,
+}
+
+// Statement 一条解析后的SELECT语句
+type Statement struct {
+	// Fields 查询字段列表
+	Fields []Field
+	// Table 表名
+	Table string
+	// Where 过滤条件，没有WHERE子句时为nil
+	Where Expr
+	// GroupByTime GROUP BY time(...) 的时间桶宽度，0表示没有按时间分组
+	GroupByTime time.Duration
+	// OrderBy 排序字段，为空表示按存储顺序
+	OrderBy string
+	// Desc 是否降序
+	Desc bool
+	// Limit 最多返回的行数，0表示不限制
+	Limit int
+}
+
+// Field 查询字段
+type Field struct {
+	// Func 聚合函数名称（小写），为空表示原始字段
+	Func string
+	// Column 字段名称，"*" 表示全部字段
+	Column string
+	// Alias 结果中的列名
+	Alias string
+}
+
+// Name 字段在结果中的列名
+func (f Field) Name() string {
+	if len(f.Alias) > 0 {
+		return f.Alias
+	}
+	if len(f.Func) > 0 {
+		return f.Func + "(" + f.Column + ")"
+	}
+	return f.Column
+}
+
+// Expr WHERE条件表达式，为 *LogicalExpr 或 *Comparison
+type Expr interface {
+	expr()
+}
+
+// LogicalExpr AND / OR 表达式
+type LogicalExpr struct {
+	// Op "and" 或 "or"
+	Op  string
+	LHS Expr
+	RHS Expr
+}
+
+// Comparison 字段与常量的比较
+type Comparison struct {
+	Column string
+	// Op 比较运算符: = != < <= > >=
+	Op string
+	// Value 常量值: int64、float64、string 或 time.Time
+	Value interface{}
+}
+
+func (*LogicalExpr) expr() {}
+func (*Comparison) expr()  {}
+
+// parser 递归下降语法分析器
+type parser struct {
+	tokens []token
+	pos    int
+	now    time.Time
+}
+
+// Parse 解析一条查询语句
+// SELECT fields FROM table [WHERE cond] [GROUP BY time(d)] [ORDER BY col [ASC|DESC]] [LIMIT n]
+func Parse(query string) (*Statement, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, now: time.Now()}
+	return p.parseSelect()
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tk := p.tokens[p.pos]
+	if tk.kind != tkEOF {
+		p.pos++
+	}
+	return tk
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	tk := p.peek()
+	where := "end of query"
+	if tk.kind != tkEOF {
+		where = fmt.Sprintf("%q at %d", tk.text, tk.pos)
+	}
+	return fmt.Errorf(format+", near "+where, args...)
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if !p.peek().keyword(kw) {
+		return p.errorf("expected %s", strings.ToUpper(kw))
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) expect(kind int, what string) (token, error) {
+	if p.peek().kind != kind {
+		return token{}, p.errorf("expected %s", what)
+	}
+	return p.next(), nil
+}
+
+func (p *parser) parseIdent() (string, error) {
+	tk, err := p.expect(tkIdent, "identifier")
+	if err != nil {
+		return "", err
+	}
+	return tk.text, nil
+}
+
+func (p *parser) parseSelect() (*Statement, error) {
+	stmt := &Statement{}
+	if err := p.expectKeyword("select"); err != nil {
+		return nil, err
+	}
+	for {
+		f, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Fields = append(stmt.Fields, f)
+		if p.peek().kind != tkComma {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectKeyword("from"); err != nil {
+		return nil, err
+	}
+	table, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Table = table
+	if p.peek().keyword("where") {
+		p.next()
+		if stmt.Where, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek().keyword("group") {
+		p.next()
+		if err = p.expectKeyword("by"); err != nil {
+			return nil, err
+		}
+		if stmt.GroupByTime, err = p.parseGroupByTime(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek().keyword("order") {
+		p.next()
+		if err = p.expectKeyword("by"); err != nil {
+			return nil, err
+		}
+		if stmt.OrderBy, err = p.parseIdent(); err != nil {
+			return nil, err
+		}
+		if p.peek().keyword("desc") {
+			p.next()
+			stmt.Desc = true
+		} else if p.peek().keyword("asc") {
+			p.next()
+		}
+	}
+	if p.peek().keyword("limit") {
+		p.next()
+		tk, err := p.expect(tkNumber, "number")
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(tk.text)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid LIMIT %q", tk.text)
+		}
+		stmt.Limit = n
+	}
+	if p.peek().kind != tkEOF {
+		return nil, p.errorf("unexpected token")
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseField() (Field, error) {
+	var f Field
+	if p.peek().kind == tkStar {
+		p.next()
+		f.Column = "*"
+		return f, nil
+	}
+	name, err := p.parseIdent()
+	if err != nil {
+		return f, err
+	}
+	if p.peek().kind == tkLParen {
+		fn := strings.ToLower(name)
+		if !aggregateFuncs[fn] {
+			return f, fmt.Errorf("unknown function %s", name)
+		}
+		if fn == "avg" {
+			fn = "mean"
+		}
+		p.next()
+		if p.peek().kind == tkStar {
+			p.next()
+			if fn != "count" {
+				return f, fmt.Errorf("%s(*) is not supported", fn)
+			}
+			f.Column = "*"
+		} else if f.Column, err = p.parseIdent(); err != nil {
+			return f, err
+		}
+		if _, err = p.expect(tkRParen, ")"); err != nil {
+			return f, err
+		}
+		f.Func = fn
+	} else {
+		f.Column = name
+	}
+	if p.peek().keyword("as") {
+		p.next()
+		if f.Alias, err = p.parseIdent(); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+func (p *parser) parseGroupByTime() (time.Duration, error) {
+	if !p.peek().keyword("time") {
+		return 0, p.errorf("only GROUP BY time(...) is supported")
+	}
+	p.next()
+	if _, err := p.expect(tkLParen, "("); err != nil {
+		return 0, err
+	}
+	tk, err := p.expect(tkDuration, "duration")
+	if err != nil {
+		return 0, err
+	}
+	d, err := parseDuration(tk.text)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, errors.New("GROUP BY time interval must be positive")
+	}
+	if _, err = p.expect(tkRParen, ")"); err != nil {
+		return 0, err
+	}
+	return d, nil
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	lhs, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().keyword("or") {
+		p.next()
+		rhs, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &LogicalExpr{Op: "or", LHS: lhs, RHS: rhs}
+	}
+	return lhs, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	lhs, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().keyword("and") {
+		p.next()
+		rhs, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &LogicalExpr{Op: "and", LHS: lhs, RHS: rhs}
+	}
+	return lhs, nil
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	if p.peek().kind == tkLParen {
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = p.expect(tkRParen, ")"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	column, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	tk, err := p.expect(tkOp, "comparison operator")
+	if err != nil {
+		return nil, err
+	}
+	op := tk.text
+	switch op {
+	case "=", "!=", "<", "<=", ">", ">=":
+	case "<>":
+		op = "!="
+	default:
+		return nil, fmt.Errorf("unexpected operator %s", op)
+	}
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return &Comparison{Column: column, Op: op, Value: value}, nil
+}
+
+// parseValue 解析常量: 数字、字符串、now() [+|- 时间长度]
+func (p *parser) parseValue() (interface{}, error) {
+	tk := p.next()
+	switch {
+	case tk.kind == tkOp && tk.text == "-":
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		switch n := v.(type) {
+		case int64:
+			return -n, nil
+		case float64:
+			return -n, nil
+		}
+		return nil, errors.New("unary minus applies to numbers only")
+	case tk.kind == tkNumber:
+		if i, err := strconv.ParseInt(tk.text, 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(tk.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", tk.text)
+		}
+		return f, nil
+	case tk.kind == tkString:
+		return tk.text, nil
+	case tk.keyword("now"):
+		if _, err := p.expect(tkLParen, "("); err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tkRParen, ")"); err != nil {
+			return nil, err
+		}
+		t := p.now
+		if op := p.peek(); op.kind == tkOp && (op.text == "+" || op.text == "-") {
+			p.next()
+			d, err := p.expect(tkDuration, "duration")
+			if err != nil {
+				return nil, err
+			}
+			dur, err := parseDuration(d.text)
+			if err != nil {
+				return nil, err
+			}
+			if op.text == "-" {
+				dur = -dur
+			}
+			t = t.Add(dur)
+		}
+		return t, nil
+	}
+	if tk.kind == tkEOF {
+		return nil, errors.New("expected value, near end of query")
+	}
+	return nil, fmt.Errorf("expected value, near %q at %d", tk.text, tk.pos)
+}
+
+// parseDuration 解析时间长度，在time.ParseDuration的基础上支持d（天）和w（周）
+func parseDuration(s string) (time.Duration, error) {
+	for unit, scale := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(s, unit) {
+			n, err := strconv.ParseInt(strings.TrimSuffix(s, unit), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(n) * scale, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,68 @@
+package b2query
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSelect(t *testing.T) {
+	stmt, err := Parse(`select mean(temperature) AS avg_temp, count(*) from cpu
+		where host = 'web1' and (temperature > -1.5 or temperature <> 0) and time >= now() - 1h
+		group by time(5m) order by time desc limit 10`)
+	if err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+	if stmt.Table != "cpu" || len(stmt.Fields) != 2 || stmt.GroupByTime != 5*time.Minute ||
+		stmt.OrderBy != "time" || !stmt.Desc || stmt.Limit != 10 {
+		t.Errorf("unexpected statement: %+v", stmt)
+	}
+	if f := stmt.Fields[0]; f.Func != "mean" || f.Column != "temperature" || f.Name() != "avg_temp" {
+		t.Errorf("unexpected first field: %+v", f)
+	}
+	if f := stmt.Fields[1]; f.Func != "count" || f.Column != "*" || f.Name() != "count(*)" {
+		t.Errorf("unexpected second field: %+v", f)
+	}
+	and, ok := stmt.Where.(*LogicalExpr)
+	if !ok || and.Op != "and" {
+		t.Fatalf("unexpected where clause: %#v", stmt.Where)
+	}
+	if cmp, ok := and.RHS.(*Comparison); !ok || cmp.Column != "time" || cmp.Op != ">=" {
+		t.Errorf("unexpected time comparison: %#v", and.RHS)
+	} else if _, ok = cmp.Value.(time.Time); !ok {
+		t.Errorf("now() - 1h should be a time value, got %T", cmp.Value)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	queries := []string{
+		"",
+		"select from cpu",
+		"select * cpu",
+		"select median(x) from cpu",
+		"select sum(*) from cpu",
+		"select * from cpu where x ! 1",
+		"select * from cpu where x = 'open",
+		"select * from cpu group by host",
+		"select * from cpu limit -1",
+		"select * from cpu extra",
+	}
+	for _, q := range queries {
+		if _, err := Parse(q); err == nil {
+			t.Errorf("expected parsing %q to fail", q)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	cases := map[string]time.Duration{
+		"500ms": 500 * time.Millisecond,
+		"90s":   90 * time.Second,
+		"2d":    48 * time.Hour,
+		"1w":    7 * 24 * time.Hour,
+	}
+	for s, want := range cases {
+		if got, err := parseDuration(s); err != nil || got != want {
+			t.Errorf("parseDuration(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package b2schema
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+)
+
+// Aggregates 一个时间桶内某个字段的聚合结果，所有数值类型统一以float64计算
+// Count为非NULL值的个数，对任何类型的字段都有效；其余各项只对数值字段有效
+type Aggregates struct {
+	Count int64
+	Sum   float64
+	Min   float64
+	Max   float64
+	Mean  float64
+	First float64
+	Last  float64
+	// firstTime, lastTime 用于在不同序列交错到达时确定first/last
+	firstTime int64
+	lastTime  int64
+}
+
+// add 向聚合结果中加入一个时间为ts的值
+func (a *Aggregates) add(ts int64, v float64) {
+	if a.Count == 0 {
+		a.Min, a.Max = v, v
+		a.First, a.firstTime = v, ts
+		a.Last, a.lastTime = v, ts
+	}
+	a.Count++
+	a.Sum += v
+	a.Mean = a.Sum / float64(a.Count)
+	if v < a.Min {
+		a.Min = v
+	}
+	if v > a.Max {
+		a.Max = v
+	}
+	if ts < a.firstTime {
+		a.First, a.firstTime = v, ts
+	}
+	if ts >= a.lastTime {
+		a.Last, a.lastTime = v, ts
+	}
+}
+
+// Bucket 一个时间桶的聚合结果
+type Bucket struct {
+	// Start 时间桶起始时间，时间桶覆盖 [Start, Start+Interval)
+	Start time.Time
+	// Count 时间桶内的行数
+	Count int64
+	// Values 以字段名称为键的聚合结果
+	Values map[string]*Aggregates
+}
+
+// AggregateOptions 聚合查询参数
+type AggregateOptions struct {
+	// Start, End 查询时间区间 [Start, End)
+	Start time.Time
+	End   time.Time
+	// Interval 时间桶宽度，以Unix纪元对齐；为0时整个区间作为一个桶，桶起始时间为Start
+	Interval time.Duration
+	// Columns 参与聚合的字段名称，为空时聚合全部数值字段；非数值字段只统计Count
+	Columns []string
+	// Filter 行过滤条件，返回false的行不参与聚合；为nil时不过滤
+	Filter func(Row) bool
+}
+
+// Aggregate 按时间桶对字段做count/sum/min/max/mean/first/last聚合，结果按时间升序排列
+// 没有数据的时间桶不会出现在结果中；没有时间轴字段的表只能以Interval为0聚合整张表
+func (t *B2Table) Aggregate(db *B2Database, opts AggregateOptions) ([]Bucket, error) {
+	if opts.Interval < 0 {
+		return nil, errors.New("negative aggregation interval")
+	}
+	columns, err := t.aggregateColumns(opts.Columns)
+	if err != nil {
+		return nil, err
+	}
+	// 过滤条件可能用到任何字段，此时读取整行
+	scanColumns := columns
+	if opts.Filter != nil {
+		scanColumns = nil
+	}
+	scan := func(fn func(Row) bool) error {
+		return t.QueryTimeRangeFunc(db, opts.Start, opts.End, scanColumns, fn)
+	}
+	if len(t.TimeColumn) == 0 {
+		// 普通表没有时间轴，不能按时间分桶，整张表聚合为一个桶，first/last按行键中的写入时间确定
+		if opts.Interval > 0 {
+			log.Printf("表 %s 没有定义时间轴字段，不能按时间分桶聚合\n", t.TableName)
+			return nil, errors.New("table has no time column")
+		}
+		// 读取整行，投影字段都为NULL的行也要计入行数
+		scan = func(fn func(Row) bool) error {
+			return t.ScanFunc(db, ScanOptions{}, fn)
+		}
+	}
+	buckets := make(map[int64]*Bucket)
+	err = scan(func(row Row) bool {
+		ts, ok := rowKeyTime(row.Key)
+		if !ok || (opts.Filter != nil && !opts.Filter(row)) {
+			return true
+		}
+		start := bucketStart(ts, opts)
+		b, ok := buckets[start]
+		if !ok {
+			b = &Bucket{Start: time.Unix(0, start), Values: make(map[string]*Aggregates, len(columns))}
+			buckets[start] = b
+		}
+		b.Count++
+		for _, name := range columns {
+			value, ok := row.Values[name]
+			if !ok {
+				// NULL不参与聚合
+				continue
+			}
+			agg, ok := b.Values[name]
+			if !ok {
+				agg = &Aggregates{}
+				b.Values[name] = agg
+			}
+			if v, ok := numericValue(value); ok {
+				agg.add(ts, v)
+			} else {
+				agg.Count++
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Bucket, 0, len(buckets))
+	for _, b := range buckets {
+		out = append(out, *b)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Start.Before(out[j].Start)
+	})
+	return out, nil
+}
+
+// bucketStart 计算时间ts所属时间桶的起始时间（Unix纳秒）
+func bucketStart(ts int64, opts AggregateOptions) int64 {
+	if opts.Interval == 0 {
+		return opts.Start.UnixNano()
+	}
+	width := int64(opts.Interval)
+	start := ts - ts%width
+	if ts%width < 0 {
+		start -= width
+	}
+	return start
+}
+
+// aggregateColumns 检查并返回参与聚合的字段名称，names为空时返回表中全部数值字段（可能没有）
+func (t *B2Table) aggregateColumns(names []string) ([]string, error) {
+	if len(names) == 0 {
+		for _, col := range t.Columns {
+			if col.IsNumeric() {
+				names = append(names, col.ColumnName)
+			}
+		}
+		return names, nil
+	}
+	for _, name := range names {
+		if t.column(name) == nil {
+			log.Printf("表 %s 中不存在字段 %s\n", t.TableName, name)
+			return nil, errors.New("no such column")
+		}
+	}
+	return names, nil
+}
+
+// IsNumeric 字段是否为数值类型
+func (col *B2Column) IsNumeric() bool {
+	t, err := NameAsType(col.DataType)
+	if err != nil {
+		return false
+	}
+	switch t.Dtype {
+	case DtInt32, DtInt64, DtFloat32, DtFloat64:
+		return true
+	}
+	return false
+}
+
+// numericValue 将数值类型的字段值转换为float64
+func numericValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
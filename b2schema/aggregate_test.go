@@ -0,0 +1,35 @@
+package b2schema
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketStart(t *testing.T) {
+	opts := AggregateOptions{Interval: time.Minute}
+	cases := map[int64]int64{
+		0:                              0,
+		int64(90 * time.Second):        int64(time.Minute),
+		int64(-30 * time.Second):       int64(-time.Minute),
+		int64(-time.Minute):            int64(-time.Minute),
+		int64(time.Hour + time.Second): int64(time.Hour),
+	}
+	for ts, want := range cases {
+		if got := bucketStart(ts, opts); got != want {
+			t.Errorf("bucketStart(%d) = %d, want %d", ts, got, want)
+		}
+	}
+}
+
+func TestAggregatesOutOfOrder(t *testing.T) {
+	var agg Aggregates
+	agg.add(20, 2.0)
+	agg.add(10, 1.0)
+	agg.add(30, 6.0)
+	if agg.Count != 3 || agg.Sum != 9 || agg.Mean != 3 || agg.Min != 1 || agg.Max != 6 {
+		t.Errorf("unexpected aggregates: %+v", agg)
+	}
+	if agg.First != 1.0 || agg.Last != 6.0 {
+		t.Errorf("first/last should follow time order: %+v", agg)
+	}
+}
@@ -0,0 +1,309 @@
+package b2schema
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+// 备份目录布局: B2META/ 元数据库检查点，<DatabaseID>/ 各数据库的检查点，MANIFEST.json 备份清单
+// 清单最后写入，没有清单的备份目录是不完整的备份
+
+// backupManifestFile 备份清单文件名
+const backupManifestFile = "MANIFEST.json"
+
+// BackupManifest 备份清单，记录元数据库检查点中的数据库目录及其对应的数据检查点
+type BackupManifest struct {
+	// CreateTime 备份时间
+	CreateTime time.Time `json:"CreateTime"`
+	// MetaDir 元数据库检查点目录，相对于备份目录
+	MetaDir string `json:"MetaDir"`
+	// MetaSyncTime 备份时元数据最后一次修改的时间
+	MetaSyncTime time.Time `json:"MetaSyncTime"`
+	// Databases 元数据库检查点中的全部数据库
+	Databases []BackupDatabase `json:"Databases"`
+}
+
+// BackupDatabase 备份清单中的一个数据库
+type BackupDatabase struct {
+	// Database 数据库名称
+	Database string `json:"Database"`
+	// DatabaseID 数据库ID
+	DatabaseID string `json:"DatabaseID"`
+	// TableList 元数据库检查点中这个数据库的表
+	TableList []string `json:"TableList,omitempty"`
+	// Dir 数据检查点目录，相对于备份目录
+	Dir string `json:"Dir"`
+}
+
+// Backup 在线备份整个实例: 元数据库和其中的全部数据库，dest必须是不存在的目录
+// open 为当前进程中已经打开的数据库，其余数据库临时打开后备份，被其他进程占用时备份失败
+// 备份期间持有元数据锁，不能新建或删除数据库和表，数据写入不受影响
+// 每个rocksdb的检查点各自一致，备份开始后写入的数据可能出现在部分数据检查点中
+func (c *MetaDBSource) Backup(dest string, open ...*B2Database) (manifest *BackupManifest, err error) {
+	if _, err = os.Stat(dest); err == nil {
+		log.Printf("备份目录 %s 已经存在\n", dest)
+		return nil, errors.New("backup directory exists")
+	}
+	if err = os.MkdirAll(dest, 0755); err != nil {
+		log.Printf("创建备份目录 %s 时发生错误: %v\n", dest, err)
+		return nil, err
+	}
+	defer func() {
+		// 不完整的备份没有用处，失败时整个删除
+		if err != nil {
+			_ = os.RemoveAll(dest)
+		}
+	}()
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	dbs, err := c.listDatabases()
+	if err != nil {
+		return nil, err
+	}
+	manifest = &BackupManifest{CreateTime: time.Now(), MetaDir: METADB, MetaSyncTime: c.SyncTime}
+	if err = createCheckpoint(c.rocksDB.NewCheckpoint, filepath.Join(dest, METADB)); err != nil {
+		log.Printf("创建元数据库检查点时发生错误: %v\n", err)
+		return nil, err
+	}
+	cfg := configOrDefault(c.Config)
+	for _, b2db := range dbs {
+		dir := filepath.Join(dest, b2db.DatabaseID)
+		if conn := openDatabase(open, b2db.DatabaseID); conn != nil {
+			err = createCheckpoint(conn.NewCheckpoint, dir)
+		} else {
+			err = checkpointClosed(cfg, b2db.DatabaseID, dir)
+		}
+		if err != nil {
+			log.Printf("创建数据库 %s 的检查点时发生错误: %v\n", b2db.Database, err)
+			return nil, err
+		}
+		manifest.Databases = append(manifest.Databases, BackupDatabase{
+			Database:   b2db.Database,
+			DatabaseID: b2db.DatabaseID,
+			TableList:  b2db.TableList,
+			Dir:        b2db.DatabaseID,
+		})
+	}
+	if err = writeManifest(dest, manifest); err != nil {
+		log.Printf("写入备份清单时发生错误: %v\n", err)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Restore 把backupDir中的备份还原到cfg的数据根目录，数据根目录中不能已经有元数据库或同名的数据库目录
+// 备份中的文件复制或硬链接到数据根目录，备份本身不会被打开或修改；还原失败时删除已经还原的目录
+// 在线备份的检查点中没有干净关闭标记，还原后第一次打开数据库时从数据重建索引，随后保存快照
+func Restore(backupDir string, cfg *Config) (manifest *BackupManifest, err error) {
+	cfg = configOrDefault(cfg)
+	if manifest, err = readManifest(backupDir); err != nil {
+		return nil, err
+	}
+	targets := []string{manifest.MetaDir}
+	for _, d := range manifest.Databases {
+		targets = append(targets, d.DatabaseID)
+	}
+	for _, name := range targets {
+		if _, err = os.Stat(cfg.path(name)); err == nil {
+			log.Printf("数据目录 %s 已经存在，不能还原\n", cfg.path(name))
+			return nil, errors.New("data root is not empty")
+		}
+	}
+	if err = cfg.ensureDirs(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, name := range targets {
+			_ = os.RemoveAll(cfg.path(name))
+			if len(cfg.WalDir) > 0 {
+				_ = os.RemoveAll(filepath.Join(cfg.WalDir, name))
+			}
+		}
+	}()
+	if err = restoreCheckpoint(filepath.Join(backupDir, manifest.MetaDir), cfg.path(METADB)); err != nil {
+		log.Printf("还原元数据库时发生错误: %v\n", err)
+		return nil, err
+	}
+	for _, d := range manifest.Databases {
+		if err = restoreCheckpoint(filepath.Join(backupDir, d.Dir), cfg.path(d.DatabaseID)); err != nil {
+			log.Printf("还原数据库 %s 时发生错误: %v\n", d.Database, err)
+			return nil, err
+		}
+	}
+	meta := OpenMetaConn(cfg)
+	defer meta.Close()
+	for _, d := range manifest.Databases {
+		if err = reopenRestored(meta, d); err != nil {
+			log.Printf("打开还原后的数据库 %s 时发生错误: %v\n", d.Database, err)
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// listDatabases 列出元数据库中的全部数据库，数据库META的键是不含"/"的数据库名称
+func (c *MetaDBSource) listDatabases() ([]*B2Database, error) {
+	wopts := rdb.NewDefaultWriteOptions()
+	topts := rdb.NewDefaultTransactionOptions()
+	txn := c.rocksDB.TransactionBegin(wopts, topts, nil)
+	defer txn.Destroy()
+	defer func() { _ = txn.Rollback() }()
+	it := txn.NewIterator(rdb.NewDefaultReadOptions())
+	defer it.Close()
+	var dbs []*B2Database
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		key := it.Key()
+		name := string(key.Data())
+		key.Free()
+		if strings.Contains(name, "/") {
+			continue
+		}
+		value := it.Value()
+		var b2db B2Database
+		err := json.Unmarshal(value.Data(), &b2db)
+		value.Free()
+		if err != nil {
+			log.Printf("数据库 %s 的META结构有错误: %v\n", name, err)
+			return nil, err
+		}
+		dbs = append(dbs, &b2db)
+	}
+	if err := it.Err(); err != nil {
+		log.Printf("读取元数据库时发生错误: %v\n", err)
+		return nil, err
+	}
+	return dbs, nil
+}
+
+// openDatabase 在已经打开的数据库中按ID查找，返回其事务连接
+func openDatabase(open []*B2Database, databaseID string) *rdb.TransactionDB {
+	for _, b2db := range open {
+		if b2db != nil && b2db.DatabaseID == databaseID && b2db.RocksDbWriteConn != nil {
+			return b2db.RocksDbWriteConn
+		}
+	}
+	return nil
+}
+
+// createCheckpoint 创建一个rocksdb检查点，写入检查点前先把memtable刷到磁盘
+func createCheckpoint(newCheckpoint func() (*rdb.Checkpoint, error), dir string) error {
+	cp, err := newCheckpoint()
+	if err != nil {
+		return err
+	}
+	defer cp.Destroy()
+	return cp.CreateCheckpoint(dir, 0)
+}
+
+// checkpointClosed 临时打开没有打开的数据库并创建检查点
+func checkpointClosed(cfg *Config, databaseID, dir string) error {
+	conn, err := rdb.OpenDb(cfg.options(databaseID), cfg.path(databaseID))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return createCheckpoint(conn.NewCheckpoint, dir)
+}
+
+// restoreCheckpoint 把备份中的检查点文件放到还原后的数据目录
+// SST文件不会再被修改，优先使用硬链接，不在同一文件系统时复制；其余文件打开数据库后可能被改写，一律复制
+func restoreCheckpoint(src, dest string) error {
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			log.Printf("检查点 %s 中不应该有子目录 %s\n", src, f.Name())
+			return errors.New("unexpected directory in checkpoint")
+		}
+		from, to := filepath.Join(src, f.Name()), filepath.Join(dest, f.Name())
+		if filepath.Ext(f.Name()) == ".sst" && os.Link(from, to) == nil {
+			continue
+		}
+		if err = copyFile(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile 复制一个文件并同步到磁盘
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// reopenRestored 打开并关闭还原后的数据库，打开时从数据重建索引，关闭时保存索引快照
+func reopenRestored(meta *MetaDBSource, d BackupDatabase) error {
+	b2db, err := meta.GetDatabase(d.Database)
+	if err != nil {
+		return err
+	}
+	if b2db.DatabaseID != d.DatabaseID {
+		log.Printf("备份清单中数据库 %s 的ID %s 与元数据库中的 %s 不一致\n", d.Database, d.DatabaseID, b2db.DatabaseID)
+		return errors.New("manifest does not match meta")
+	}
+	if b2db, err = b2db.OpenConnection(meta.Config); err != nil {
+		return err
+	}
+	b2db.Close()
+	return nil
+}
+
+// writeManifest 写入备份清单，先写临时文件再改名
+func writeManifest(dir string, manifest *BackupManifest) error {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, backupManifestFile+".tmp")
+	if err = ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, backupManifestFile))
+}
+
+// readManifest 读取备份清单
+func readManifest(dir string) (*BackupManifest, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, backupManifestFile))
+	if err != nil {
+		log.Printf("读取备份 %s 的清单时发生错误，备份可能不完整: %v\n", dir, err)
+		return nil, err
+	}
+	var manifest BackupManifest
+	if err = json.Unmarshal(content, &manifest); err != nil {
+		log.Printf("备份 %s 的清单格式有错误: %v\n", dir, err)
+		return nil, err
+	}
+	return &manifest, nil
+}
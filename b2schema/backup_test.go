@@ -0,0 +1,159 @@
+package b2schema
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// newBackupTable 新建备份测试用的表，写入web-server-01和web-server-02各一行
+func newBackupTable(t *testing.T, b2db *B2Database) *B2Table {
+	cols := make([]B2Column, 3)
+	cols[0] = *NewColumn("ts", "timestamp")
+	cols[1] = *NewColumn("host", "string").Length(32).Dict(true).Index(true)
+	cols[2] = *NewColumn("region", "string").Length(16).Dict(true).DefaultValue("eu-west")
+	table, err := NewTimeSeriesTable("hosts", cols, "ts", "host", b2db, meta)
+	if err != nil {
+		t.Fatalf("creating hosts failed: %v", err)
+	}
+	base := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, host := range []string{"web-server-01", "web-server-02"} {
+		if _, err = table.InsertByValues(b2db, base.Add(time.Duration(i)*time.Minute), host, nil); err != nil {
+			t.Fatalf("inserting into hosts failed: %v", err)
+		}
+	}
+	return table
+}
+
+// readDir 读取目录中全部文件的内容，文件名->内容
+func readDir(t *testing.T, dir string) map[string][]byte {
+	contents := make(map[string][]byte)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		contents[path] = data
+		return err
+	})
+	if err != nil {
+		t.Fatalf("reading %s failed: %v", dir, err)
+	}
+	return contents
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	b2db := openTestDatabase(t, "backupDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	table := newBackupTable(t, b2db)
+	dir, err := ioutil.TempDir("", "babydb-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	backup := filepath.Join(dir, "backup")
+	manifest, err := meta.Backup(backup, b2db)
+	if err != nil {
+		t.Fatalf("backing up failed: %v", err)
+	}
+	var backedUp bool
+	for _, d := range manifest.Databases {
+		backedUp = backedUp || (d.Database == b2db.Database && d.DatabaseID == b2db.DatabaseID)
+	}
+	if !backedUp {
+		t.Errorf("backup manifest misses %s: %+v", b2db.Database, manifest)
+	}
+	// 备份之后写入的数据不在备份中
+	if _, err = table.InsertByValues(b2db, time.Date(2019, 1, 1, 1, 0, 0, 0, time.UTC), "web-server-02", nil); err != nil {
+		t.Fatalf("inserting into hosts failed: %v", err)
+	}
+	if _, err = meta.Backup(backup, b2db); err == nil {
+		t.Error("backing up into an existing directory should fail")
+	}
+	before := readDir(t, backup)
+	cfg := &Config{DataRoot: filepath.Join(dir, "data")}
+	if _, err = Restore(backup, cfg); err != nil {
+		t.Fatalf("restoring failed: %v", err)
+	}
+	after := readDir(t, backup)
+	if len(after) != len(before) {
+		t.Errorf("restoring changed the backup: %d files before, %d after", len(before), len(after))
+	}
+	for path, data := range before {
+		if !bytes.Equal(after[path], data) {
+			t.Errorf("restoring modified %s in the backup", path)
+		}
+	}
+	if _, err = Restore(backup, cfg); err == nil {
+		t.Error("restoring into a non-empty data root should fail")
+	}
+	restoredMeta := OpenMetaConn(cfg)
+	defer restoredMeta.Close()
+	restored, err := restoredMeta.GetDatabase(b2db.Database)
+	if err != nil {
+		t.Fatalf("restored META has no %s: %v", b2db.Database, err)
+	}
+	if restored, err = restored.OpenConnection(cfg); err != nil {
+		t.Fatalf("opening restored database failed: %v", err)
+	}
+	defer restored.Close()
+	if table, err = restored.GetTable("hosts", restoredMeta); err != nil {
+		t.Fatal("get restored hosts META failed")
+	}
+	found, err := table.LookupByIndex(restored, "host", "web-server-02")
+	if err != nil || len(found) != 1 || found[0].Values["region"] != "eu-west" {
+		t.Errorf("unexpected lookup in restored database: %v, %v", found, err)
+	}
+}
+
+func TestBackupAndRestoreCleanUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "babydb-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// META中登记了但没有数据文件的数据库无法备份
+	ghost := &B2Database{Database: "ghostDB", DatabaseID: xid.New().String(), CreateTime: time.Now()}
+	if err = meta.PutDatabase(ghost); err != nil {
+		t.Fatalf("registering ghostDB failed: %v", err)
+	}
+	backup := filepath.Join(dir, "backup")
+	_, err = meta.Backup(backup)
+	if derr := meta.DelDatabase(ghost.Database); derr != nil {
+		t.Fatalf("unregistering ghostDB failed: %v", derr)
+	}
+	if err == nil {
+		t.Fatal("backing up a database without data files should fail")
+	}
+	if _, err = os.Stat(backup); !os.IsNotExist(err) {
+		t.Errorf("a failed backup should be removed: %v", err)
+	}
+
+	// 清单中的数据库检查点不存在，已经还原的元数据库要删除
+	if _, err = meta.Backup(backup); err != nil {
+		t.Fatalf("backing up failed: %v", err)
+	}
+	manifest, err := readManifest(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.Databases = append(manifest.Databases, BackupDatabase{Database: "ghostDB", DatabaseID: ghost.DatabaseID, Dir: ghost.DatabaseID})
+	if err = os.Remove(filepath.Join(backup, backupManifestFile)); err != nil {
+		t.Fatal(err)
+	}
+	if err = writeManifest(backup, manifest); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{DataRoot: filepath.Join(dir, "data")}
+	if _, err = Restore(backup, cfg); err == nil {
+		t.Fatal("restoring a backup with a missing checkpoint should fail")
+	}
+	if _, err = os.Stat(cfg.path(METADB)); !os.IsNotExist(err) {
+		t.Errorf("a failed restore should remove the restored meta database: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package b2schema
+
+import (
+	"errors"
+	"log"
+
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+// BatchMode 批量写入时遇到校验失败的行的处理方式
+type BatchMode int
+
+// 批量写入模式
+const (
+	BatchFailAll     BatchMode = iota // 任何一行校验失败，整批都不写入
+	BatchSkipInvalid                  // 跳过校验失败的行，写入其余的行
+)
+
+// ErrInvalidBatch 批量写入中有行校验失败，整批没有写入
+var ErrInvalidBatch = errors.New("batch contains invalid rows")
+
+// BatchResult 批量写入中一行的结果，Err为nil时Key是写入的行键
+type BatchResult struct {
+	Key string
+	Err error
+}
+
+// InsertBatch 在一个事务中向表中插入多行数据，每行的值按字段顺序排列
+// 返回与rows一一对应的结果，BatchFailAll模式下有行校验失败时返回ErrInvalidBatch，所有行都不写入
+func (t *B2Table) InsertBatch(db *B2Database, rows [][]interface{}, mode BatchMode) ([]BatchResult, error) {
+	results := make([]BatchResult, len(rows))
+	encodedRows := make([]map[string][]byte, len(rows))
+	invalid := 0
+	for i, values := range rows {
+		encoded, err := t.encodeValues(values)
+		if err != nil {
+			results[i].Err = err
+			invalid++
+			continue
+		}
+		encodedRows[i] = encoded
+	}
+	if invalid > 0 && mode == BatchFailAll {
+		log.Printf("批量写入表 %s 时有 %d 行校验失败，整批不写入\n", t.TableName, invalid)
+		return results, ErrInvalidBatch
+	}
+	if invalid == len(rows) {
+		return results, nil
+	}
+	wopts := rdb.NewDefaultWriteOptions()
+	topts := rdb.NewDefaultTransactionOptions()
+	txn := db.RocksDbWriteConn.TransactionBegin(wopts, topts, nil)
+	defer txn.Destroy()
+	ops := newIndexOps(t)
+	for i, encoded := range encodedRows {
+		if encoded == nil {
+			continue
+		}
+		rowKey := t.rowKeyFor(encoded)
+		if err := t.writeRow(txn, rowKey, encoded); err != nil {
+			_ = txn.Rollback()
+			return nil, err
+		}
+		ops.insertRow(rowKey, encoded)
+		results[i].Key = rowKey
+	}
+	if err := ops.commit(txn, db); err != nil {
+		log.Printf("提交事务时发生错误: %v\n", err)
+		_ = txn.Rollback()
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,34 @@
+package b2schema
+
+import (
+	"testing"
+)
+
+func TestInsertBatch(t *testing.T) {
+	b2db := openTestDatabase(t, "batchDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	table := newUsersTable(t, b2db, "alice")
+	before := b2db.Indexes.IDIndexLen(table.TableID)
+	rows := [][]interface{}{
+		{"frank", int32(50)},
+		{"grace", "not an int32"},
+		{"heidi", int32(52)},
+	}
+	results, err := table.InsertBatch(b2db, rows, BatchFailAll)
+	if err != ErrInvalidBatch || results[1].Err == nil || results[0].Key != "" {
+		t.Fatalf("expected the whole batch to fail, got %v, %v", results, err)
+	}
+	if n := b2db.Indexes.IDIndexLen(table.TableID); n != before {
+		t.Errorf("failed batch changed the ID index: %d -> %d", before, n)
+	}
+	results, err = table.InsertBatch(b2db, rows, BatchSkipInvalid)
+	if err != nil || results[1].Err == nil || results[0].Key == "" || results[2].Key == "" {
+		t.Fatalf("expected the bad row to be skipped, got %v, %v", results, err)
+	}
+	if row, err := table.GetRow(b2db, results[2].Key); err != nil || row["username"] != "heidi" {
+		t.Errorf("unexpected batch row: %v, %v", row, err)
+	}
+	if found, _ := table.LookupByIndex(b2db, "username", "frank"); len(found) != 1 {
+		t.Errorf("batch row was not indexed: %v", found)
+	}
+}
@@ -0,0 +1,491 @@
+package b2schema
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/rs/xid"
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+// 压缩块: 把一个序列中一个压缩字段的连续多个点按Gorilla格式打包为一个键值对
+// 存储键: TableID + "#chunk/" + ColumnID + "/" + uvarint(len(series)) + series + 块中最后一个点的时间戳(8字节)
+// 存储值: Gorilla编码的时间戳和值，后接每个点所在行行键中的xid(12字节)
+// 块中的点按序列、时间戳和xid与行对应，打包后删除这些点各自的 rowKey/ColumnID 键值对
+// 行中单独存储的字段值优先于压缩块中的值
+
+// chunkMaxPoints 一个压缩块最多包含的点数
+const chunkMaxPoints = 1024
+
+// errChunkChanged 块中的点在打包期间被修改，这个块没有写入
+var errChunkChanged = errors.New("point changed during compaction")
+
+// Compress 设置字段值是否可以打包为压缩块，只适用于时间序列表中没有索引的数值字段
+func (col *B2Column) Compress(c bool) *B2Column {
+	col.Compressed = c
+	return col
+}
+
+// chunkBits 将数值字段的值转换为压缩块中的64位表示
+func chunkBits(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return uint64(int64(v)), true
+	case int64:
+		return uint64(v), true
+	case float32:
+		return uint64(math.Float32bits(v)), true
+	case float64:
+		return math.Float64bits(v), true
+	}
+	return 0, false
+}
+
+// chunkValue chunkBits的逆操作，按字段数据类型还原值
+func (col *B2Column) chunkValue(bits uint64) interface{} {
+	t, err := NameAsType(col.DataType)
+	if err != nil {
+		return nil
+	}
+	switch t.Dtype {
+	case DtInt32:
+		return int32(int64(bits))
+	case DtInt64:
+		return int64(bits)
+	case DtFloat32:
+		return math.Float32frombits(uint32(bits))
+	case DtFloat64:
+		return math.Float64frombits(bits)
+	}
+	return nil
+}
+
+// chunkPrefix 表中所有压缩块的公共前缀，不在keyPrefix之内，扫描行数据时不会读到
+func (t *B2Table) chunkPrefix() []byte {
+	return []byte(t.TableID + "#chunk/")
+}
+
+// chunkSeriesPrefix 某个字段在某个序列中全部压缩块的公共前缀，series为seriesPrefix的结果
+func (t *B2Table) chunkSeriesPrefix(col *B2Column, series []byte) []byte {
+	prefix := append(t.chunkPrefix(), col.ColumnID...)
+	prefix = append(prefix, '/')
+	return append(prefix, series[len(t.keyPrefix()):]...)
+}
+
+// compressedColumns 返回表中全部压缩字段
+func (t *B2Table) compressedColumns() []*B2Column {
+	var cols []*B2Column
+	for i := range t.Columns {
+		if t.Columns[i].Compressed {
+			cols = append(cols, &t.Columns[i])
+		}
+	}
+	return cols
+}
+
+// chunk 解码后的压缩块
+type chunk struct {
+	key    []byte
+	ts     []int64
+	ids    []string
+	values []uint64
+}
+
+// packChunk 将一组按时间升序排列的点编码为压缩块的存储值，ids为各点所在行行键中的xid
+func packChunk(ts []int64, ids []string, values []uint64) []byte {
+	data := encodeChunk(ts, values)
+	for _, id := range ids {
+		data = append(data, id...)
+	}
+	return data
+}
+
+// unpackChunk packChunk的逆操作
+func unpackChunk(data []byte) ([]int64, []string, []uint64, error) {
+	count, n := binary.Uvarint(data)
+	idLen := len(xid.ID{})
+	if n <= 0 || count > uint64(len(data)/idLen) {
+		return nil, nil, nil, errCorruptChunk
+	}
+	pos := len(data) - int(count)*idLen
+	ts, values, err := decodeChunk(data[:pos])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ids := make([]string, count)
+	for i := range ids {
+		ids[i] = string(data[pos+i*idLen : pos+(i+1)*idLen])
+	}
+	return ts, ids, values, nil
+}
+
+// covers 块的时间范围是否包含ts
+func (c *chunk) covers(ts int64) bool {
+	return len(c.ts) > 0 && c.ts[0] <= ts && ts <= c.ts[len(c.ts)-1]
+}
+
+// find 查找时间戳为ts、xid为id的点，同一时间戳的其他行不会被当作这个点
+func (c *chunk) find(ts int64, id string) (int, bool) {
+	i := sort.Search(len(c.ts), func(i int) bool { return c.ts[i] >= ts })
+	for ; i < len(c.ts) && c.ts[i] == ts; i++ {
+		if c.ids[i] == id {
+			return i, true
+		}
+	}
+	return i, false
+}
+
+// chunkReader 按行键读取压缩块中的字段值，缓存每个字段最近一次读到的块
+// 顺序读取同一个序列时，大部分点可以直接从缓存的块中取得
+type chunkReader struct {
+	table   *B2Table
+	columns []*B2Column
+	it      *rdb.Iterator
+	txn     *rdb.Transaction
+	cached  map[string]*chunk
+}
+
+// openChunkReader 在事务连接上创建压缩块读取器，表没有压缩字段时返回nil，使用完毕后必须调用返回的release函数
+func (t *B2Table) openChunkReader(db *B2Database) (*chunkReader, func()) {
+	cols := t.compressedColumns()
+	if len(cols) == 0 {
+		return nil, func() {}
+	}
+	it, release := db.newIterator(rdb.NewDefaultReadOptions())
+	return &chunkReader{table: t, columns: cols, it: it, cached: make(map[string]*chunk)}, release
+}
+
+// txnChunkReader 在写事务中创建压缩块读取器，可以看到事务中已经改写的块，表没有压缩字段时返回nil
+func (t *B2Table) txnChunkReader(txn *rdb.Transaction) *chunkReader {
+	cols := t.compressedColumns()
+	if len(cols) == 0 {
+		return nil
+	}
+	return &chunkReader{table: t, columns: cols, txn: txn, cached: make(map[string]*chunk)}
+}
+
+// load 读取某个字段在行键所在序列中包含ts的压缩块，没有这样的块时返回nil
+func (r *chunkReader) load(col *B2Column, series []byte, ts int64) (*chunk, error) {
+	prefix := r.table.chunkSeriesPrefix(col, series)
+	if c, ok := r.cached[col.ColumnID]; ok && bytes.HasPrefix(c.key, prefix) && c.covers(ts) {
+		return c, nil
+	}
+	it := r.it
+	if r.txn != nil {
+		// 事务中的块可能已经被改写，每次重新创建迭代器
+		it = r.txn.NewIterator(rdb.NewDefaultReadOptions())
+		defer it.Close()
+	}
+	// 块键以块中最后一个点的时间戳结尾，第一个不小于ts的块键就是可能包含ts的块
+	it.Seek(append(prefix, encodeKeyTime(ts)...))
+	if !it.ValidForPrefix(prefix) {
+		return nil, it.Err()
+	}
+	key := it.Key()
+	value := it.Value()
+	c := &chunk{key: append([]byte(nil), key.Data()...)}
+	var err error
+	c.ts, c.ids, c.values, err = unpackChunk(value.Data())
+	key.Free()
+	value.Free()
+	if err != nil {
+		log.Printf("解析表 %s 字段 %s 的压缩块时发生错误: %v\n", r.table.TableName, col.ColumnName, err)
+		return nil, err
+	}
+	r.cached[col.ColumnID] = c
+	if !c.covers(ts) {
+		return nil, nil
+	}
+	return c, nil
+}
+
+// fill 为一行补上没有单独存储的压缩字段值，projected为nil时补全部压缩字段
+func (r *chunkReader) fill(rowKey string, values map[string]interface{}, projected map[string]bool) error {
+	if r == nil {
+		return nil
+	}
+	series, ok := rowKeySeriesPrefix(rowKey)
+	if !ok {
+		return nil
+	}
+	ts, _ := rowKeyTime(rowKey)
+	id, _ := rowKeyID(rowKey)
+	for _, col := range r.columns {
+		if _, ok := values[col.ColumnName]; ok || (projected != nil && !projected[col.ColumnName]) {
+			continue
+		}
+		c, err := r.load(col, series, ts)
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			continue
+		}
+		if i, ok := c.find(ts, id); ok {
+			values[col.ColumnName] = col.chunkValue(c.values[i])
+		}
+	}
+	return nil
+}
+
+// removePoint 在事务中从压缩块里删除一行的字段值，块中没有这个点时什么也不做
+func (r *chunkReader) removePoint(col *B2Column, rowKey string) error {
+	if r == nil || !col.Compressed {
+		return nil
+	}
+	series, ok := rowKeySeriesPrefix(rowKey)
+	if !ok {
+		return nil
+	}
+	ts, _ := rowKeyTime(rowKey)
+	id, _ := rowKeyID(rowKey)
+	c, err := r.load(col, series, ts)
+	if err != nil || c == nil {
+		return err
+	}
+	i, ok := c.find(ts, id)
+	if !ok {
+		return nil
+	}
+	if err = r.txn.Delete(c.key); err != nil {
+		return err
+	}
+	rest := &chunk{
+		ts:     append(append([]int64(nil), c.ts[:i]...), c.ts[i+1:]...),
+		ids:    append(append([]string(nil), c.ids[:i]...), c.ids[i+1:]...),
+		values: append(append([]uint64(nil), c.values[:i]...), c.values[i+1:]...),
+	}
+	if len(rest.ts) == 0 {
+		delete(r.cached, col.ColumnID)
+		return nil
+	}
+	// 删除最后一个点后块键随之改变
+	rest.key = append(r.table.chunkSeriesPrefix(col, series), encodeKeyTime(rest.ts[len(rest.ts)-1])...)
+	if err = r.txn.Put(rest.key, packChunk(rest.ts, rest.ids, rest.values)); err != nil {
+		return err
+	}
+	r.cached[col.ColumnID] = rest
+	return nil
+}
+
+// CompactChunks 把表中时间早于before的压缩字段值按序列打包为压缩块，返回打包的点数
+// 每个序列只打包晚于已有压缩块的点，同一序列中时间戳重复的行不打包
+// 每个块在单独的事务中写入，打包期间被修改的点会跳过，留到下一次打包
+func (t *B2Table) CompactChunks(db *B2Database, before time.Time) (int, error) {
+	if len(t.TimeColumn) == 0 {
+		log.Printf("表 %s 没有定义时间轴字段\n", t.TableName)
+		return 0, errors.New("table has no time column")
+	}
+	cols := t.compressedColumns()
+	if len(cols) == 0 {
+		return 0, nil
+	}
+	byID, _, err := t.projection(nil)
+	if err != nil {
+		return 0, err
+	}
+	projected := make(map[string]bool, len(cols))
+	for _, col := range cols {
+		projected[col.ColumnName] = true
+	}
+	chunks, releaseChunks := db.newIterator(rdb.NewDefaultReadOptions())
+	defer releaseChunks()
+	it, release := db.newIterator(rdb.NewDefaultReadOptions())
+	defer release()
+	c := &chunkCompactor{table: t, db: db, columns: cols, chunks: chunks, before: before.UnixNano()}
+	prefix := t.keyPrefix()
+	it.Seek(prefix)
+	_, err = t.collectRows(it, byID, projected, nil, func(key []byte) bool {
+		return bytes.HasPrefix(key, prefix)
+	}, func(row Row) bool {
+		err = c.add(row)
+		return err == nil
+	})
+	if err == nil {
+		err = c.flush()
+	}
+	if err != nil {
+		log.Printf("打包表 %s 的压缩块时发生错误: %v\n", t.TableName, err)
+	}
+	return c.compacted, err
+}
+
+// compactPoint 等待打包的一行
+type compactPoint struct {
+	rowKey string
+	ts     int64
+	values map[string]interface{}
+}
+
+// chunkCompactor 按行键顺序接收一张表的行，逐个序列打包压缩块
+type chunkCompactor struct {
+	table     *B2Table
+	db        *B2Database
+	columns   []*B2Column
+	chunks    *rdb.Iterator
+	before    int64
+	series    []byte
+	lastEnd   map[string]int64 // ColumnID -> 当前序列已有压缩块的结束时间
+	skipped   map[string]bool  // ColumnID -> 当前序列中是否有块因为点被修改而跳过
+	pending   []compactPoint
+	dupTs     int64
+	hasDup    bool
+	compacted int
+}
+
+// add 接收一行，序列变化或者等待打包的行足够一个块时写入压缩块
+func (c *chunkCompactor) add(row Row) error {
+	series, ok := rowKeySeriesPrefix(row.Key)
+	if !ok {
+		return nil
+	}
+	ts, _ := rowKeyTime(row.Key)
+	if !bytes.Equal(series, c.series) {
+		if err := c.flush(); err != nil {
+			return err
+		}
+		c.series = series
+		c.hasDup = false
+		if err := c.loadLastEnd(); err != nil {
+			return err
+		}
+	}
+	if ts >= c.before || (c.hasDup && ts == c.dupTs) {
+		return nil
+	}
+	if n := len(c.pending); n > 0 && c.pending[n-1].ts == ts {
+		// 时间戳重复的点无法按时间戳与行对应，全部留在行中
+		for n > 0 && c.pending[n-1].ts == ts {
+			n--
+		}
+		c.pending = c.pending[:n]
+		c.dupTs, c.hasDup = ts, true
+		return nil
+	}
+	// 新的点时间戳与之前的都不同，之前的点不会再因为重复被排除
+	if len(c.pending) >= chunkMaxPoints {
+		if err := c.flush(); err != nil {
+			return err
+		}
+	}
+	c.pending = append(c.pending, compactPoint{rowKey: row.Key, ts: ts, values: row.Values})
+	return nil
+}
+
+// loadLastEnd 读取当前序列中每个压缩字段最后一个压缩块的结束时间
+func (c *chunkCompactor) loadLastEnd() error {
+	c.lastEnd = make(map[string]int64, len(c.columns))
+	c.skipped = make(map[string]bool, len(c.columns))
+	for _, col := range c.columns {
+		prefix := c.table.chunkSeriesPrefix(col, c.series)
+		if next := prefixSuccessor(prefix); next != nil {
+			c.chunks.Seek(next)
+		}
+		if c.chunks.Valid() {
+			c.chunks.Prev()
+		} else {
+			c.chunks.SeekToLast()
+		}
+		if c.chunks.ValidForPrefix(prefix) {
+			key := c.chunks.Key()
+			data := key.Data()
+			c.lastEnd[col.ColumnID] = decodeKeyTime(data[len(data)-rowKeyTimeLen:])
+			key.Free()
+		}
+		if err := c.chunks.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush 把等待打包的行按字段写成压缩块
+// 一个字段的块被跳过后，这个序列中该字段后面的点也不再打包，否则块的结束时间越过跳过的点，这些点再也不会被打包
+func (c *chunkCompactor) flush() error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+	for _, col := range c.columns {
+		if c.skipped[col.ColumnID] {
+			continue
+		}
+		var points []compactPoint
+		last, hasChunk := c.lastEnd[col.ColumnID]
+		for _, p := range c.pending {
+			if _, ok := p.values[col.ColumnName]; ok && (!hasChunk || p.ts > last) {
+				points = append(points, p)
+			}
+		}
+		if len(points) == 0 {
+			continue
+		}
+		err := c.writeChunk(col, points)
+		if err == errChunkChanged {
+			c.skipped[col.ColumnID] = true
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	c.pending = c.pending[:0]
+	return nil
+}
+
+// writeChunk 在一个事务中写入一个压缩块并删除块中各点单独存储的字段值，有点被修改时返回errChunkChanged
+func (c *chunkCompactor) writeChunk(col *B2Column, points []compactPoint) error {
+	ts := make([]int64, len(points))
+	ids := make([]string, len(points))
+	values := make([]uint64, len(points))
+	wopts := rdb.NewDefaultWriteOptions()
+	topts := rdb.NewDefaultTransactionOptions()
+	ropts := rdb.NewDefaultReadOptions()
+	txn := c.db.RocksDbWriteConn.TransactionBegin(wopts, topts, nil)
+	defer txn.Destroy()
+	for i, p := range points {
+		value := p.values[col.ColumnName]
+		encoded, err := col.FormatBytes(value)
+		if err != nil {
+			_ = txn.Rollback()
+			return err
+		}
+		key := rowColumnKey(p.rowKey, col.ColumnID)
+		slice, err := txn.GetForUpdate(ropts, key)
+		if err != nil {
+			_ = txn.Rollback()
+			return err
+		}
+		unchanged := slice.Exists() && bytes.Equal(slice.Data(), encoded)
+		slice.Free()
+		if !unchanged {
+			log.Printf("表 %s 行 %s 字段 %s 在打包期间被修改，本次跳过\n", c.table.TableName, p.rowKey, col.ColumnName)
+			_ = txn.Rollback()
+			return errChunkChanged
+		}
+		if err = txn.Delete(key); err != nil {
+			_ = txn.Rollback()
+			return err
+		}
+		ts[i] = p.ts
+		ids[i], _ = rowKeyID(p.rowKey)
+		values[i], _ = chunkBits(value)
+	}
+	key := append(c.table.chunkSeriesPrefix(col, c.series), encodeKeyTime(ts[len(ts)-1])...)
+	if err := txn.Put(key, packChunk(ts, ids, values)); err != nil {
+		_ = txn.Rollback()
+		return err
+	}
+	if err := txn.Commit(); err != nil {
+		log.Printf("提交事务时发生错误: %v\n", err)
+		_ = txn.Rollback()
+		return err
+	}
+	c.lastEnd[col.ColumnID] = ts[len(ts)-1]
+	c.compacted += len(points)
+	return nil
+}
@@ -0,0 +1,218 @@
+package b2schema
+
+import (
+	"testing"
+	"time"
+
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+func TestCompactChunks(t *testing.T) {
+	cols := make([]B2Column, 4)
+	cols[0] = *NewColumn("ts", "timestamp")
+	cols[1] = *NewColumn("host", "string").Length(32)
+	cols[2] = *NewColumn("cpu", "float64").Compress(true)
+	cols[3] = *NewColumn("load", "int32").Compress(true)
+	b2db := openTestDatabase(t, "compactDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	table, err := NewTimeSeriesTable("cpu", cols, "ts", "host", b2db, meta)
+	if err != nil {
+		t.Fatalf("creating cpu failed: %v", err)
+	}
+	base := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	want := make(map[string]map[string]interface{})
+	for i := 0; i < 1500; i++ {
+		values := []interface{}{base.Add(time.Duration(i) * 10 * time.Second), "web1", 20 + float64(i%7)/4, int32(i % 3)}
+		if i%100 == 5 {
+			values[3] = nil
+		}
+		key, err := table.InsertByValues(b2db, values...)
+		if err != nil {
+			t.Fatalf("inserting into cpu failed: %v", err)
+		}
+		want[key] = map[string]interface{}{"cpu": values[2]}
+		if values[3] != nil {
+			want[key]["load"] = values[3]
+		}
+	}
+	// 时间戳重复的两行不会被打包
+	for _, cpu := range []float64{1, 2} {
+		key, err := table.InsertByValues(b2db, base, "web2", cpu, int32(9))
+		if err != nil {
+			t.Fatalf("inserting into cpu failed: %v", err)
+		}
+		want[key] = map[string]interface{}{"cpu": cpu, "load": int32(9)}
+	}
+	n, err := table.CompactChunks(b2db, base.Add(time.Hour))
+	if err != nil || n != 2*360-4 {
+		t.Fatalf("expected %d points compacted, got %d, %v", 2*360-4, n, err)
+	}
+	n, err = table.CompactChunks(b2db, base.Add(24*time.Hour))
+	if err != nil || n != 2*1140-11 {
+		t.Fatalf("expected %d points compacted, got %d, %v", 2*1140-11, n, err)
+	}
+	if n, _ = table.CompactChunks(b2db, base.Add(24*time.Hour)); n != 0 {
+		t.Errorf("compacted points should not be compacted again, got %d", n)
+	}
+	check := func(rows []Row) {
+		if len(rows) != len(want) {
+			t.Fatalf("expected %d rows, got %d", len(want), len(rows))
+		}
+		for _, row := range rows {
+			w := want[row.Key]
+			if row.Values["cpu"] != w["cpu"] || row.Values["load"] != w["load"] {
+				t.Fatalf("row %q: expected %v, got %v", row.Key, w, row.Values)
+			}
+		}
+	}
+	rows, _, err := table.Scan(b2db, ScanOptions{})
+	if err != nil {
+		t.Fatalf("scanning cpu failed: %v", err)
+	}
+	check(rows)
+	ranged, err := table.QueryTimeRange(b2db, base, base.Add(24*time.Hour), "cpu", "load")
+	if err != nil {
+		t.Fatalf("querying cpu failed: %v", err)
+	}
+	check(ranged)
+	row, err := table.GetRow(b2db, rows[700].Key)
+	if err != nil || row["cpu"] != want[rows[700].Key]["cpu"] {
+		t.Errorf("reading a compacted row failed: %v, %v", row, err)
+	}
+	// 删除和改为NULL都要从压缩块中去掉对应的点
+	if err = table.DeleteByKey(b2db, rows[700].Key); err != nil {
+		t.Fatalf("deleting a compacted row failed: %v", err)
+	}
+	delete(want, rows[700].Key)
+	if err = table.UpdateByKey(b2db, rows[701].Key, map[string]interface{}{"cpu": nil}); err != nil {
+		t.Fatalf("updating a compacted row failed: %v", err)
+	}
+	delete(want[rows[701].Key], "cpu")
+	if err = table.UpdateByKey(b2db, rows[702].Key, map[string]interface{}{"cpu": 99.5}); err != nil {
+		t.Fatalf("updating a compacted row failed: %v", err)
+	}
+	want[rows[702].Key]["cpu"] = 99.5
+	if rows, _, err = table.Scan(b2db, ScanOptions{}); err != nil {
+		t.Fatalf("scanning cpu failed: %v", err)
+	}
+	check(rows)
+	if err = b2db.TruncateTable("cpu", meta); err != nil {
+		t.Fatalf("truncating cpu failed: %v", err)
+	}
+	it, release := b2db.newIterator(rdb.NewDefaultReadOptions())
+	defer release()
+	if it.Seek(table.chunkPrefix()); it.ValidForPrefix(table.chunkPrefix()) {
+		t.Error("chunks left after truncate")
+	}
+}
+
+func TestUpdateAndDeleteCompactedRows(t *testing.T) {
+	b2db := openTestDatabase(t, "chunkDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	cols := make([]B2Column, 3)
+	cols[0] = *NewColumn("ts", "timestamp")
+	cols[1] = *NewColumn("host", "string").Length(32)
+	cols[2] = *NewColumn("load", "int32").Compress(true)
+	table, err := NewTimeSeriesTable("metrics", cols, "ts", "host", b2db, meta)
+	if err != nil {
+		t.Fatalf("creating metrics failed: %v", err)
+	}
+	base := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	keys := make([]string, 3)
+	for i := range keys {
+		if keys[i], err = table.InsertByValues(b2db, base.Add(time.Duration(i)*time.Second), "web1", int32(i)); err != nil {
+			t.Fatalf("inserting into metrics failed: %v", err)
+		}
+	}
+	if n, err := table.CompactChunks(b2db, base.Add(time.Hour)); err != nil || n != 3 {
+		t.Fatalf("expected 3 points compacted, got %d, %v", n, err)
+	}
+
+	// 修改压缩后的点再改为NULL，压缩块中的旧值不能重新出现
+	if err = table.UpdateByKey(b2db, keys[0], map[string]interface{}{"load": int32(7)}); err != nil {
+		t.Fatalf("updating compacted row failed: %v", err)
+	}
+	if row, err := table.GetRow(b2db, keys[0]); err != nil || row["load"] != int32(7) {
+		t.Errorf("unexpected row after update: %v, %v", row, err)
+	}
+	if err = table.UpdateByKey(b2db, keys[0], map[string]interface{}{"load": nil}); err != nil {
+		t.Fatalf("setting compacted row to NULL failed: %v", err)
+	}
+	if row, err := table.GetRow(b2db, keys[0]); err != nil || row["load"] != nil {
+		t.Errorf("compacted value came back after set-NULL: %v, %v", row, err)
+	}
+
+	// 修改压缩后的点再删除，同一时间戳的新行不能读到压缩块中的旧值
+	if err = table.UpdateByKey(b2db, keys[1], map[string]interface{}{"load": int32(8)}); err != nil {
+		t.Fatalf("updating compacted row failed: %v", err)
+	}
+	if err = table.DeleteByKey(b2db, keys[1]); err != nil {
+		t.Fatalf("deleting compacted row failed: %v", err)
+	}
+	key, err := table.InsertByValues(b2db, base.Add(time.Second), "web1", nil)
+	if err != nil {
+		t.Fatalf("inserting into metrics failed: %v", err)
+	}
+	if row, err := table.GetRow(b2db, key); err != nil || row["load"] != nil {
+		t.Errorf("deleted compacted value leaked into a new row: %v, %v", row, err)
+	}
+	if row, err := table.GetRow(b2db, keys[2]); err != nil || row["load"] != int32(2) {
+		t.Errorf("untouched compacted row changed: %v, %v", row, err)
+	}
+
+	// 与压缩后的点序列和时间戳相同的新行既不能读到这个点，删除时也不能删掉这个点
+	if key, err = table.InsertByValues(b2db, base.Add(2*time.Second), "web1", nil); err != nil {
+		t.Fatalf("inserting into metrics failed: %v", err)
+	}
+	if row, err := table.GetRow(b2db, key); err != nil || row["load"] != nil {
+		t.Errorf("a new row read the compacted value of another row: %v, %v", row, err)
+	}
+	if err = table.DeleteByKey(b2db, key); err != nil {
+		t.Fatalf("deleting the new row failed: %v", err)
+	}
+	if row, err := table.GetRow(b2db, keys[2]); err != nil || row["load"] != int32(2) {
+		t.Errorf("deleting another row removed the compacted point: %v, %v", row, err)
+	}
+}
+
+func TestCompactSkipsChangedPoints(t *testing.T) {
+	b2db := openTestDatabase(t, "chunkSkipDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	cols := make([]B2Column, 3)
+	cols[0] = *NewColumn("ts", "timestamp")
+	cols[1] = *NewColumn("host", "string").Length(32)
+	cols[2] = *NewColumn("load", "int32").Compress(true)
+	table, err := NewTimeSeriesTable("metrics", cols, "ts", "host", b2db, meta)
+	if err != nil {
+		t.Fatalf("creating metrics failed: %v", err)
+	}
+	base := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if _, err = table.InsertByValues(b2db, base.Add(time.Duration(i)*time.Second), "web1", int32(i)); err != nil {
+			t.Fatalf("inserting into metrics failed: %v", err)
+		}
+	}
+	rows, _, err := table.Scan(b2db, ScanOptions{})
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("scanning metrics failed: %v, %v", rows, err)
+	}
+	// 模拟第一个点在读出之后被修改: 第一个块被跳过，同一序列中后面的块也不能写入
+	chunks, release := b2db.newIterator(rdb.NewDefaultReadOptions())
+	defer release()
+	c := &chunkCompactor{table: table, db: b2db, columns: table.compressedColumns(), chunks: chunks, before: base.Add(time.Hour).UnixNano()}
+	rows[0].Values["load"] = int32(5)
+	for _, row := range rows {
+		if err = c.add(row); err != nil {
+			t.Fatalf("adding a row failed: %v", err)
+		}
+		if err = c.flush(); err != nil {
+			t.Fatalf("flushing failed: %v", err)
+		}
+	}
+	if c.compacted != 0 {
+		t.Errorf("points after a skipped chunk should not be compacted, got %d", c.compacted)
+	}
+	if n, err := table.CompactChunks(b2db, base.Add(time.Hour)); err != nil || n != 2 {
+		t.Errorf("skipped points should be compacted by the next run, got %d, %v", n, err)
+	}
+}
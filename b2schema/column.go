@@ -5,6 +5,7 @@ import (
 	"errors"
 	"log"
 	"math"
+	"time"
 
 	"github.com/rs/xid"
 )
@@ -25,9 +26,25 @@ type B2Column struct {
 	ColumnID string `json:"ColumnID"`
 	// IndexID 索引全局唯一ID
 	IndexID string `json:"IndexID"`
+	// NotNull 是否不允许NULL，NULL值不写入存储。没有这一项的旧表META按允许NULL处理
+	NotNull bool `json:"NotNull,omitempty"`
+	// Precision timestamp字段的精度: s、ms、us或ns，为空表示ns
+	// 整数值按这个精度解释为Unix时间，写入的时间会截断到这个精度
+	Precision string `json:"Precision,omitempty"`
+	// Default 按字段类型编码后的默认值，没有提供值时使用，为空表示没有默认值
+	Default []byte `json:"Default,omitempty"`
+	// Compressed 字段值是否可以打包为压缩块，见CompactChunks
+	Compressed bool `json:"Compressed,omitempty"`
+	// Dictionary 是否使用字典编码，行中只存储字符串在字典中的ID
+	Dictionary bool `json:"Dictionary,omitempty"`
+	// dict 字典编码字段的字典，从打开的数据库中取得表META时关联
+	dict *dictionary
 }
 
-// NewColumn 创建一个新的字段，仅包括基本字段名称和数据类型
+// ErrNotNull 没有为不允许NULL并且没有默认值的字段提供值
+var ErrNotNull = errors.New("column is not nullable")
+
+// NewColumn 创建一个新的字段，仅包括基本字段名称和数据类型，字段默认允许NULL
 func NewColumn(name, dt string) *B2Column {
 	return &B2Column{
 		ColumnName: name,
@@ -51,6 +68,78 @@ func (col *B2Column) Index(i bool) *B2Column {
 	return col
 }
 
+// Null 设置字段是否允许NULL
+func (col *B2Column) Null(n bool) *B2Column {
+	col.NotNull = !n
+	return col
+}
+
+// TimePrecision 设置timestamp字段的精度: s、ms、us或ns
+func (col *B2Column) TimePrecision(p string) *B2Column {
+	col.Precision = p
+	return col
+}
+
+// precisionUnit 返回timestamp字段精度对应的时长
+func (col *B2Column) precisionUnit() (time.Duration, error) {
+	switch col.Precision {
+	case "", "ns":
+		return time.Nanosecond, nil
+	case "us":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	}
+	return 0, errors.New("unknown timestamp precision")
+}
+
+// formatTime 将time.Time或按字段精度表示的Unix时间整数编码为可排序的字节数组
+func (col *B2Column) formatTime(value interface{}) ([]byte, error) {
+	unit, err := col.precisionUnit()
+	if err != nil {
+		log.Printf("字段 %s 的时间精度 %s 有错误\n", col.ColumnName, col.Precision)
+		return nil, err
+	}
+	var n int64
+	switch v := value.(type) {
+	case time.Time:
+		return TimeToBytes(v.Truncate(unit)), nil
+	case int64:
+		n = v
+	case int32:
+		n = int64(v)
+	case int:
+		n = int64(v)
+	default:
+		log.Printf("值 %v 与字段数据类型 %s 定义不相符，无法转换\n", value, col.DataType)
+		return nil, errors.New("value and column data type mismatched")
+	}
+	limit := int64(math.MaxInt64 / unit)
+	if n > limit || n < -limit {
+		log.Printf("时间值 %d 超出字段 %s 可以表示的范围\n", n, col.ColumnName)
+		return nil, errors.New("timestamp out of range")
+	}
+	return TimeToBytes(time.Unix(0, n*int64(unit))), nil
+}
+
+// DefaultValue 设置字段默认值，值与字段数据类型不符时不设置默认值
+// 字典编码字段的默认值保存原始字符串，写入时再换成ID
+func (col *B2Column) DefaultValue(value interface{}) *B2Column {
+	if s, ok := value.(string); ok && col.Dictionary {
+		col.Default = []byte(s)
+		return col
+	}
+	bs, err := col.FormatBytes(value)
+	if err != nil {
+		log.Printf("字段 %s 的默认值 %v 与数据类型不符，没有设置默认值\n", col.ColumnName, value)
+		return col
+	}
+	col.Default = bs
+	return col
+}
+
 // FormatBytes 将一个值按照字段数据类型定义转换为一个字节数组值
 func (col *B2Column) FormatBytes(value interface{}) ([]byte, error) {
 	t, err := NameAsType(col.DataType)
@@ -59,25 +148,31 @@ func (col *B2Column) FormatBytes(value interface{}) ([]byte, error) {
 		return nil, err
 	}
 	if v, ok := value.(int32); t.Dtype == DtInt32 && ok {
-		return int32ToBytes(v), nil
+		return Int32ToBytes(v), nil
 	}
 	if v, ok := value.(int64); t.Dtype == DtInt64 && ok {
-		return int64ToBytes(v), nil
+		return Int64ToBytes(v), nil
 	}
 	if v, ok := value.(float32); t.Dtype == DtFloat32 && ok {
-		return float32ToBytes(v), nil
+		return Float32ToBytes(v), nil
 	}
 	if v, ok := value.(float64); t.Dtype == DtFloat64 && ok {
-		return float64ToBytes(v), nil
+		return Float64ToBytes(v), nil
 	}
 	if v, ok := value.(string); t.Dtype == DtString && ok {
+		if col.Dictionary {
+			return col.dictEncode(v)
+		}
 		return []byte(v), nil
 	}
 	if v, ok := value.([]byte); t.Dtype == DtBytes && ok {
 		return v, nil
 	}
 	if v, ok := value.(int64); t.Dtype == DtBytes && ok {
-		return int64ToBytes(v), nil
+		return Int64ToBytes(v), nil
+	}
+	if t.Dtype == DtTimestamp {
+		return col.formatTime(value)
 	}
 	log.Printf("值 %v 与字段数据类型 %s 定义不相符，无法转换\n", value, col.DataType)
 	return nil, errors.New("value and column data type mismatched")
@@ -94,19 +189,28 @@ func (col *B2Column) ParseMap(value []byte) (map[string]interface{}, error) {
 	switch t.Dtype {
 	case DtInt32:
 		// 是否需要检查字节数组长度？
-		out[col.ColumnName] = bytesToInt32(value)
+		out[col.ColumnName] = BytesToInt32(value)
 	case DtInt64:
-		out[col.ColumnName] = bytesToInt64(value)
+		out[col.ColumnName] = BytesToInt64(value)
 	case DtFloat32:
-		out[col.ColumnName] = bytesToFloat32(value)
+		out[col.ColumnName] = BytesToFloat32(value)
 	case DtFloat64:
-		out[col.ColumnName] = bytesToFloat64(value)
+		out[col.ColumnName] = BytesToFloat64(value)
 	case DtString:
-		out[col.ColumnName] = string(value)
+		if !col.Dictionary {
+			out[col.ColumnName] = string(value)
+			break
+		}
+		s, err := col.dictDecode(value)
+		if err != nil {
+			return nil, err
+		}
+		out[col.ColumnName] = s
 	case DtBytes:
-		out[col.ColumnName] = value
+		// 复制一份，避免引用rocksdb持有的内存
+		out[col.ColumnName] = append([]byte(nil), value...)
 	case DtTimestamp:
-		out[col.ColumnName] = bytesToInt64(value)
+		out[col.ColumnName] = BytesToTime(value)
 	}
 	return out, nil
 }
@@ -167,14 +271,55 @@ func (col *B2Column) ParseFloat64(value []byte) (float64, bool) {
 	return v, true
 }
 
-// ParseString 将一个字节数组值按照字段定义转换为string
+// ParseString 将一个字节数组值按照字段定义转换为string，字典编码字段的值先按字典还原
 func (col *B2Column) ParseString(value []byte) (string, bool) {
-	if col.DataType == "string" && len(value) <= col.DataLength {
-		return string(value), true
+	if col.DataType != "string" {
+		return "", false
+	}
+	s := string(value)
+	if col.Dictionary {
+		var err error
+		if s, err = col.dictDecode(value); err != nil {
+			return "", false
+		}
+	}
+	if len(s) <= col.DataLength {
+		return s, true
 	}
 	return "", false
 }
 
+// dictEncode 按字段的字典把字符串编码为ID，字典中没有时分配新ID
+func (col *B2Column) dictEncode(s string) ([]byte, error) {
+	if col.dict == nil {
+		log.Printf("字段 %s 的字典没有加载\n", col.ColumnName)
+		return nil, errNoDictionary
+	}
+	bs, _, err := col.dict.encode(s, true)
+	return bs, err
+}
+
+// dictDecode 按字段的字典把ID还原为字符串
+func (col *B2Column) dictDecode(value []byte) (string, error) {
+	if col.dict == nil {
+		log.Printf("字段 %s 的字典没有加载\n", col.ColumnName)
+		return "", errNoDictionary
+	}
+	s, err := col.dict.decode(value)
+	if err != nil {
+		log.Printf("字段 %s 的值 %v 不在字典中\n", col.ColumnName, value)
+	}
+	return s, err
+}
+
+// ParseTime 将一个字节数组值按照字段定义转换为time.Time
+func (col *B2Column) ParseTime(value []byte) (time.Time, bool) {
+	if col.DataType == B2Timestamp.TypeName && len(value) == 8 {
+		return BytesToTime(value), true
+	}
+	return time.Time{}, false
+}
+
 // 下面是一些二进制转换工具函数
 
 // Int32ToBytes 将一个int32值转换为字节数组
@@ -220,6 +365,18 @@ func Float64ToBytes(f float64) []byte {
 	return bs
 }
 
+// TimeToBytes 将一个时间转换为8字节大端的Unix纳秒数，翻转符号位后按字节序比较与时间先后一致
+func TimeToBytes(t time.Time) []byte {
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, uint64(t.UnixNano())^(1<<63))
+	return bs
+}
+
+// BytesToTime 将一个字节数组转换为UTC时间
+func BytesToTime(bs []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(bs)^(1<<63))).UTC()
+}
+
 // BytesToFloat64 将一个字节数组转换为字节数组
 func BytesToFloat64(bs []byte) float64 {
 	return math.Float64frombits(binary.LittleEndian.Uint64(bs))
@@ -1,13 +1,18 @@
 package b2schema
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
 
 func TestCasting(t *testing.T) {
 	int32Col := B2Column{
 		ColumnName: "an_int32_col",
 		DataType:   "int32",
 	}
-	i32bytes := int32ToBytes(1234)
+	i32bytes := Int32ToBytes(1234)
 	conv, _ := int32Col.ParseInt32(i32bytes)
 	if conv != 1234 {
 		t.Errorf("%d casting failed\n", 1234)
@@ -16,7 +21,7 @@ func TestCasting(t *testing.T) {
 		ColumnName: "an_int64_col",
 		DataType:   "int64",
 	}
-	i64bytes := int64ToBytes(123456789012345)
+	i64bytes := Int64ToBytes(123456789012345)
 	conv1, _ := int64Col.ParseInt64(i64bytes)
 	if conv1 != 123456789012345 {
 		t.Errorf("%d casting failed\n", 123456789012345)
@@ -24,7 +29,7 @@ func TestCasting(t *testing.T) {
 	float32Col := B2Column{
 		DataType: "float32",
 	}
-	f32bytes := float32ToBytes(0.12345)
+	f32bytes := Float32ToBytes(0.12345)
 	conv2, _ := float32Col.ParseFloat32(f32bytes)
 	if conv2 != 0.12345 {
 		t.Errorf("%f casting failed\n", 0.12345)
@@ -32,7 +37,7 @@ func TestCasting(t *testing.T) {
 	float64Col := B2Column{
 		DataType: "float64",
 	}
-	f64bytes := float64ToBytes(0.123457890123456789012345)
+	f64bytes := Float64ToBytes(0.123457890123456789012345)
 	conv3, _ := float64Col.ParseFloat64(f64bytes)
 	if conv3 != 0.123457890123456789012345 {
 		t.Errorf("%f casting failed\n", 0.123457890123456789012345)
@@ -45,4 +50,75 @@ func TestCasting(t *testing.T) {
 	if conv4 != "hello world" {
 		t.Errorf("hello world casting failed\n")
 	}
+	bytesCol := B2Column{
+		DataType: "bytes",
+	}
+	if bs, err := bytesCol.FormatBytes(int64(42)); err != nil || BytesToInt64(bs) != 42 {
+		t.Errorf("int64 into bytes casting failed: %v\n", err)
+	}
+}
+
+func TestColumnNullAndDefault(t *testing.T) {
+	col := NewColumn("status", "int32").Null(false).DefaultValue(int32(7))
+	data, err := json.Marshal(col)
+	if err != nil {
+		t.Fatalf("marshaling column failed: %v", err)
+	}
+	var restored B2Column
+	if err = json.Unmarshal(data, &restored); err != nil {
+		t.Fatalf("unmarshaling column failed: %v", err)
+	}
+	if !restored.NotNull {
+		t.Error("NotNull was not persisted")
+	}
+	if v, ok := restored.ParseInt32(restored.Default); !ok || v != 7 {
+		t.Errorf("Default was not persisted: %v", restored.Default)
+	}
+	if bad := NewColumn("status", "int32").DefaultValue("seven"); bad.Default != nil {
+		t.Error("a mismatched default value should not be set")
+	}
+}
+
+func TestColumnMetaWithoutNotNull(t *testing.T) {
+	// 加入NotNull之前创建的表，META中没有这一项
+	old := []byte(`{"ColumnName":"status","DataType":"int32","ColumnID":"bk1s7a1r0c8g00a0f7ag","IndexID":""}`)
+	var col B2Column
+	if err := json.Unmarshal(old, &col); err != nil {
+		t.Fatalf("unmarshaling old column META failed: %v", err)
+	}
+	if col.NotNull {
+		t.Error("a column from old META should be nullable")
+	}
+	table := &B2Table{TableName: "old", Columns: []B2Column{*NewColumn("name", "string"), col}}
+	encoded := map[string][]byte{"name": []byte("alice")}
+	if err := table.fillMissing(encoded); err != nil {
+		t.Errorf("a missing value of an old nullable column was rejected: %v", err)
+	}
+}
+
+func TestTimestampPrecision(t *testing.T) {
+	col := NewColumn("ts", "timestamp").TimePrecision("ms")
+	bs, err := col.FormatBytes(int64(1500))
+	if err != nil {
+		t.Fatalf("formatting an epoch in ms failed: %v", err)
+	}
+	if v, ok := col.ParseTime(bs); !ok || !v.Equal(time.Unix(1, 500*int64(time.Millisecond))) {
+		t.Errorf("unexpected time for 1500ms: %v", v)
+	}
+	bs, _ = col.FormatBytes(time.Unix(2, 123456789))
+	m, _ := col.ParseMap(bs)
+	if v := m["ts"].(time.Time); !v.Equal(time.Unix(2, 123000000)) {
+		t.Errorf("time.Time was not truncated to ms: %v", v)
+	}
+	early, _ := col.FormatBytes(time.Unix(-10, 0))
+	late, _ := col.FormatBytes(time.Unix(10, 0))
+	if bytes.Compare(early, late) >= 0 {
+		t.Error("encoded timestamps should sort in time order")
+	}
+	if _, err = col.FormatBytes(int64(1) << 62); err == nil {
+		t.Error("an epoch overflowing nanoseconds should be rejected")
+	}
+	if _, err = NewColumn("ts", "timestamp").TimePrecision("h").FormatBytes(int64(1)); err == nil {
+		t.Error("an unknown precision should be rejected")
+	}
 }
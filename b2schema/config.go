@@ -0,0 +1,196 @@
+package b2schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+// 配置文件路径和各配置项对应的环境变量，环境变量优先于配置文件
+const (
+	EnvConfigFile      = "BABYDB_CONFIG"
+	EnvDataRoot        = "BABYDB_DATA_ROOT"
+	EnvBlockCacheSize  = "BABYDB_BLOCK_CACHE_SIZE"
+	EnvCompression     = "BABYDB_COMPRESSION"
+	EnvWriteBufferSize = "BABYDB_WRITE_BUFFER_SIZE"
+	EnvMaxOpenFiles    = "BABYDB_MAX_OPEN_FILES"
+	EnvWalDir          = "BABYDB_WAL_DIR"
+)
+
+// compressionTypes 配置中的压缩算法名称
+var compressionTypes = map[string]rdb.CompressionType{
+	"none":   rdb.NoCompression,
+	"snappy": rdb.SnappyCompression,
+	"zlib":   rdb.ZLibCompression,
+	"bz2":    rdb.Bz2Compression,
+	"lz4":    rdb.LZ4Compression,
+	"lz4hc":  rdb.LZ4HCCompression,
+	"zstd":   rdb.ZSTDCompression,
+}
+
+// Config 数据目录与rocksdb参数配置，数值为0或字符串为空的项使用rocksdb的默认值
+type Config struct {
+	// DataRoot 数据根目录，元数据库和各数据库的rocksdb目录都建在这里，默认为进程的工作目录
+	DataRoot string `json:"DataRoot,omitempty"`
+	// BlockCacheSize 块缓存大小（字节），同一个配置打开的全部rocksdb共用一个缓存
+	BlockCacheSize uint64 `json:"BlockCacheSize,omitempty"`
+	// Compression 压缩算法: none、snappy、zlib、bz2、lz4、lz4hc或zstd
+	Compression string `json:"Compression,omitempty"`
+	// WriteBufferSize memtable大小（字节）
+	WriteBufferSize int `json:"WriteBufferSize,omitempty"`
+	// MaxOpenFiles 最多打开的文件数，-1表示不限制
+	MaxOpenFiles int `json:"MaxOpenFiles,omitempty"`
+	// WalDir WAL根目录，每个rocksdb在其中使用以自己名称命名的子目录，为空时WAL与数据放在一起
+	WalDir string `json:"WalDir,omitempty"`
+
+	cacheOnce sync.Once
+	cache     *rdb.Cache
+}
+
+// DefaultConfig 返回默认配置: 数据放在进程的工作目录，rocksdb参数全部使用默认值
+func DefaultConfig() *Config {
+	return &Config{DataRoot: "."}
+}
+
+// LoadConfig 读取配置: 先取默认值，再读取JSON配置文件，最后用环境变量覆盖
+// path为空时使用环境变量BABYDB_CONFIG指定的文件，都没有指定时不读取配置文件
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	if len(path) == 0 {
+		path = os.Getenv(EnvConfigFile)
+	}
+	if len(path) > 0 {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Printf("读取配置文件 %s 时发生错误: %v\n", path, err)
+			return nil, err
+		}
+		if err = json.Unmarshal(content, cfg); err != nil {
+			log.Printf("配置文件 %s 格式有错误: %v\n", path, err)
+			return nil, err
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv 用环境变量覆盖配置项
+func (c *Config) applyEnv() error {
+	if v, ok := os.LookupEnv(EnvDataRoot); ok {
+		c.DataRoot = v
+	}
+	if v, ok := os.LookupEnv(EnvCompression); ok {
+		c.Compression = v
+	}
+	if v, ok := os.LookupEnv(EnvWalDir); ok {
+		c.WalDir = v
+	}
+	if v, ok := os.LookupEnv(EnvBlockCacheSize); ok {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			log.Printf("环境变量 %s 的值 %q 不是非负整数\n", EnvBlockCacheSize, v)
+			return fmt.Errorf("invalid %s: %v", EnvBlockCacheSize, err)
+		}
+		c.BlockCacheSize = n
+	}
+	ints := []struct {
+		env   string
+		value *int
+	}{
+		{EnvWriteBufferSize, &c.WriteBufferSize},
+		{EnvMaxOpenFiles, &c.MaxOpenFiles},
+	}
+	for _, item := range ints {
+		v, ok := os.LookupEnv(item.env)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Printf("环境变量 %s 的值 %q 不是整数\n", item.env, v)
+			return fmt.Errorf("invalid %s: %v", item.env, err)
+		}
+		*item.value = n
+	}
+	return nil
+}
+
+// validate 检查配置项的取值
+func (c *Config) validate() error {
+	if _, ok := compressionTypes[c.Compression]; len(c.Compression) > 0 && !ok {
+		log.Printf("不支持的压缩算法 %s\n", c.Compression)
+		return errors.New("unknown compression")
+	}
+	if c.WriteBufferSize < 0 || c.MaxOpenFiles < -1 {
+		log.Printf("配置中的数值项不能为负数: %+v\n", c)
+		return errors.New("invalid config value")
+	}
+	return nil
+}
+
+// configOrDefault 未提供配置时使用默认配置
+func configOrDefault(c *Config) *Config {
+	if c == nil {
+		return DefaultConfig()
+	}
+	return c
+}
+
+// path 返回名为name的rocksdb在数据根目录下的路径
+func (c *Config) path(name string) string {
+	return filepath.Join(c.DataRoot, name)
+}
+
+// ensureDirs 创建数据根目录和WAL根目录
+func (c *Config) ensureDirs() error {
+	for _, dir := range []string{c.DataRoot, c.WalDir} {
+		if len(dir) == 0 {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Printf("创建目录 %s 时发生错误: %v\n", dir, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// options 按配置生成名为name的rocksdb的打开参数
+func (c *Config) options(name string) *rdb.Options {
+	opts := rdb.NewDefaultOptions()
+	if c.BlockCacheSize > 0 {
+		c.cacheOnce.Do(func() {
+			c.cache = rdb.NewLRUCache(c.BlockCacheSize)
+		})
+		bbto := rdb.NewDefaultBlockBasedTableOptions()
+		bbto.SetBlockCache(c.cache)
+		opts.SetBlockBasedTableFactory(bbto)
+	}
+	if ct, ok := compressionTypes[c.Compression]; ok {
+		opts.SetCompression(ct)
+	}
+	if c.WriteBufferSize > 0 {
+		opts.SetWriteBufferSize(c.WriteBufferSize)
+	}
+	if c.MaxOpenFiles != 0 {
+		opts.SetMaxOpenFiles(c.MaxOpenFiles)
+	}
+	if len(c.WalDir) > 0 {
+		// 多个rocksdb不能共用一个WAL目录
+		opts.SetWalDir(filepath.Join(c.WalDir, name))
+	}
+	return opts
+}
@@ -0,0 +1,54 @@
+package b2schema
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "babydb-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "babydb.json")
+	content := `{"DataRoot": "/var/lib/babydb", "Compression": "lz4", "WriteBufferSize": 67108864, "MaxOpenFiles": 512}`
+	if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(EnvDataRoot, dir)
+	os.Setenv(EnvBlockCacheSize, "8388608")
+	defer os.Unsetenv(EnvDataRoot)
+	defer os.Unsetenv(EnvBlockCacheSize)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("loading config failed: %v", err)
+	}
+	if cfg.DataRoot != dir || cfg.BlockCacheSize != 8388608 || cfg.Compression != "lz4" ||
+		cfg.WriteBufferSize != 67108864 || cfg.MaxOpenFiles != 512 || len(cfg.WalDir) != 0 {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if cfg.path(METADB) != filepath.Join(dir, METADB) {
+		t.Errorf("unexpected meta database path: %s", cfg.path(METADB))
+	}
+	os.Setenv(EnvMaxOpenFiles, "many")
+	if _, err = LoadConfig(path); err == nil {
+		t.Error("a non-integer environment value should be rejected")
+	}
+	os.Unsetenv(EnvMaxOpenFiles)
+	os.Setenv(EnvBlockCacheSize, "-1")
+	if _, err = LoadConfig(path); err == nil {
+		t.Error("a negative block cache size should be rejected")
+	}
+	os.Setenv(EnvBlockCacheSize, "8388608")
+	os.Setenv(EnvCompression, "gzip")
+	defer os.Unsetenv(EnvCompression)
+	if _, err = LoadConfig(path); err == nil {
+		t.Error("an unknown compression should be rejected")
+	}
+	if _, err = LoadConfig(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("a missing config file should be reported")
+	}
+}
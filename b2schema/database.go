@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/babydb/babydb/core"
 	"github.com/rs/xid"
 	rdb "github.com/tecbot/gorocksdb"
 	"github.com/thoas/go-funk"
@@ -29,6 +31,19 @@ type B2Database struct {
 	CreateTime time.Time `json:"CreateTime,omitempty"`
 	// 打开时间
 	OpenTime time.Time `json:"OpenTime,omitempty"`
+	// 数据库的内存索引，打开数据库时创建
+	Indexes *core.IndexManager `json:"-"`
+	// 打开数据库时校验失败、没有还原的索引快照ID（表ID或索引ID），OpenConnection重建这些表的索引后清空
+	InvalidIndexes []string `json:"-"`
+	// 字典编码字段的字典，ColumnID->字典，第一次使用时读入
+	dictMu sync.Mutex
+	dicts  map[string]*dictionary
+	// 每张表的提交锁和正在进行的索引重建的变更日志，表ID->锁/日志，关闭数据库和删除表时清除
+	indexMu     sync.Mutex
+	commitLocks map[string]*sync.Mutex
+	rebuilds    map[string]*rebuildJournal
+	// 取得数据库时所用的元数据库，打开时重建索引和关闭时保存索引快照都要读取表META
+	meta *MetaDBSource
 }
 
 // NewDatabase 创建一个新的数据库
@@ -52,20 +67,33 @@ func NewDatabase(name string, meta *MetaDBSource) (*B2Database, error) {
 		RocksDbWriteConn: nil,
 		CreateTime:       time.Now(),
 		OpenTime:         time.Now(),
+		meta:             meta,
 	}
 	if err = meta.PutDatabase(db); err != nil {
 		log.Fatalf("创建数据库META时发生错误: %v\n", err)
 		return nil, err
 	}
-	opts := rdb.NewDefaultOptions()
+	cfg := configOrDefault(meta.Config)
+	if err = cfg.ensureDirs(); err != nil {
+		return nil, err
+	}
+	opts := cfg.options(db.DatabaseID)
 	opts.SetCreateIfMissing(true)
 	opts.SetErrorIfExists(true)
-	create, err := rdb.OpenDb(opts, db.DatabaseID)
+	create, err := rdb.OpenDb(opts, cfg.path(db.DatabaseID))
 	if err != nil {
 		log.Fatalf("创建数据库文件时发生错误: %v\n", err)
 		return nil, err
 	}
+	// 新数据库没有数据也没有索引，写入干净关闭标记，第一次打开时不必从数据重建索引
+	wopts := rdb.NewDefaultWriteOptions()
+	wopts.SetSync(true)
+	err = create.Put(wopts, []byte(indexCleanKey), []byte(time.Now().Format(time.RFC3339Nano)))
 	create.Close()
+	if err != nil {
+		log.Printf("写入数据库 %s 的干净关闭标记时发生错误: %v\n", name, err)
+		return nil, err
+	}
 	// TODO: up broadcast meta data to global index
 	return db, nil
 }
@@ -77,7 +105,7 @@ func NewDatabaseAndOpen(name string, meta *MetaDBSource) (*B2Database, error) {
 		log.Fatalf("新建数据库时发生错误: %v\n", err)
 		return nil, err
 	}
-	b2db, err = b2db.OpenConnection()
+	b2db, err = b2db.OpenConnection(meta.Config)
 	if err != nil {
 		log.Fatalf("打开数据库时发生错误: %v\n", err)
 		return nil, err
@@ -85,21 +113,34 @@ func NewDatabaseAndOpen(name string, meta *MetaDBSource) (*B2Database, error) {
 	return b2db, nil
 }
 
-// OpenConnection 打开B2DB数据库连接
-func (b2db *B2Database) OpenConnection() (*B2Database, error) {
-	opts := rdb.NewDefaultOptions()
+// OpenConnection 打开B2DB数据库连接，cfg应与新建数据库时元数据库的配置一致，为nil时使用默认配置
+func (b2db *B2Database) OpenConnection(cfg *Config) (*B2Database, error) {
+	cfg = configOrDefault(cfg)
+	opts := cfg.options(b2db.DatabaseID)
 	topts := rdb.NewDefaultTransactionDBOptions()
-	write, err := rdb.OpenTransactionDb(opts, topts, b2db.DatabaseID)
+	write, err := rdb.OpenTransactionDb(opts, topts, cfg.path(b2db.DatabaseID))
 	if err != nil {
 		log.Fatalf("打开数据库连接时发生错误: %v\n", err)
 		return nil, err
 	}
 	opts.SetCreateIfMissing(false)
 	opts.SetErrorIfExists(false)
-	read, err := rdb.OpenDbForReadOnly(opts, b2db.DatabaseID, false)
+	read, err := rdb.OpenDbForReadOnly(opts, cfg.path(b2db.DatabaseID), false)
 	b2db.RocksDbReadConn = read
 	b2db.RocksDbWriteConn = write
 	b2db.OpenTime = time.Now()
+	b2db.Indexes = core.NewIndexManager()
+	b2db.dicts = make(map[string]*dictionary)
+	if err = b2db.resumePurges(); err != nil {
+		log.Printf("清除数据库 %s 中已删除表的数据时发生错误: %v\n", b2db.Database, err)
+		b2db.closeConns()
+		return nil, err
+	}
+	if err = b2db.openIndexes(); err != nil {
+		log.Printf("还原数据库 %s 的索引时发生错误: %v\n", b2db.Database, err)
+		b2db.closeConns()
+		return nil, err
+	}
 	return b2db, nil
 }
 
@@ -113,8 +154,8 @@ func DropDatabase(name string, meta *MetaDBSource) error {
 		return err
 	}
 	b2db.Close()
-	opts := rdb.NewDefaultOptions()
-	if err = rdb.DestroyDb(b2db.DatabaseID, opts); err != nil {
+	cfg := configOrDefault(meta.Config)
+	if err = rdb.DestroyDb(cfg.path(b2db.DatabaseID), cfg.options(b2db.DatabaseID)); err != nil {
 		log.Fatalf("删除数据库文件时发生错误: %v\n", err)
 		return err
 	}
@@ -125,9 +166,20 @@ func DropDatabase(name string, meta *MetaDBSource) error {
 	return nil
 }
 
-// GetTable 在元数据中获取某个数据库表META内容
+// GetTable 在元数据中获取某个数据库表META内容，存储格式过旧的表返回ErrTableFormat
+// 数据库已经打开时，字典编码字段会关联数据库中的字典
 func (b2db *B2Database) GetTable(tableName string, meta *MetaDBSource) (*B2Table, error) {
-	return meta.getTable(b2db.Database, tableName)
+	table, err := meta.getTable(b2db.Database, tableName)
+	if err != nil {
+		return nil, err
+	}
+	if err = table.checkFormat(); err != nil {
+		return nil, err
+	}
+	if err = b2db.attachDictionaries(table); err != nil {
+		return nil, err
+	}
+	return table, nil
 }
 
 // AddTable 在数据库中添加表
@@ -170,19 +222,46 @@ func (b2db *B2Database) AddTable(table *B2Table, meta *MetaDBSource) error {
 	}
 	_ = txn.Commit()
 	// TODO: up broadcast meta data to global index
-	return nil
+	return b2db.attachDictionaries(table)
 }
 
-// RemoveTable 从数据库中移除表
+// RemoveTable 从数据库中移除表，同时清除表的全部数据、索引和索引快照
+// 清除前先写入清除标记，清除中断时在下次打开数据库时继续
 func (b2db *B2Database) RemoveTable(tableName string, meta *MetaDBSource) error {
+	// 清除数据不依赖字段编码，存储格式过旧的表也可以删除
+	table, err := meta.getTable(b2db.Database, tableName)
+	if err != nil {
+		// META中已经没有这张表，也就没有可以清除的数据
+		return b2db.removeTableMeta(tableName, meta)
+	}
+	if err = b2db.attachDictionaries(table); err != nil {
+		return err
+	}
+	if b2db.RocksDbWriteConn == nil {
+		log.Printf("数据库 %s 没有打开，只删除表 %s 的META，数据不会被清除\n", b2db.Database, tableName)
+		return b2db.removeTableMeta(tableName, meta)
+	}
+	// 先写清除标记再删除META，中断时由resumePurges按META是否还有这张表决定是否继续清除
+	if err = b2db.markPurge(table, true); err != nil {
+		return err
+	}
+	if err = b2db.removeTableMeta(tableName, meta); err != nil {
+		return err
+	}
+	if err = b2db.purgeTable(table, true); err != nil {
+		return err
+	}
+	b2db.forgetTable(table.TableID)
+	return nil
+}
+
+// removeTableMeta 从元数据库中删除表的META并更新数据库的表列表
+func (b2db *B2Database) removeTableMeta(tableName string, meta *MetaDBSource) error {
 	wopts := rdb.NewDefaultWriteOptions()
 	topts := rdb.NewDefaultTransactionOptions()
 	meta.Mu.Lock()
 	defer meta.Mu.Unlock()
 	txn := meta.rocksDB.TransactionBegin(wopts, topts, nil)
-
-	// TODO: remove all KV storage owned by the table
-
 	key := []byte(b2db.Database + "/" + tableName)
 	if err := txn.Delete(key); err != nil {
 		log.Fatalf("删除数据库 %s 中的表 %s 的元数据时发生错误: %v\n", b2db.Database, tableName, err)
@@ -218,12 +297,29 @@ func (b2db *B2Database) RemoveTable(tableName string, meta *MetaDBSource) error
 	return nil
 }
 
-// Close 关闭数据库连接
+// Close 保存索引快照并写入干净关闭标记后关闭数据库连接，调用前应该停止对数据库的写入
+// 保存失败时不写标记，下次打开时从数据重建索引
 func (b2db *B2Database) Close() {
+	if b2db.RocksDbWriteConn != nil && b2db.Indexes != nil && b2db.meta != nil {
+		if err := b2db.saveIndexes(b2db.meta, true); err != nil {
+			log.Printf("关闭数据库 %s 时保存索引快照发生错误: %v\n", b2db.Database, err)
+		}
+	}
+	b2db.closeConns()
+}
+
+// closeConns 关闭数据库连接
+func (b2db *B2Database) closeConns() {
 	if b2db.RocksDbReadConn != nil {
 		b2db.RocksDbReadConn.Close()
+		b2db.RocksDbReadConn = nil
 	}
 	if b2db.RocksDbWriteConn != nil {
 		b2db.RocksDbWriteConn.Close()
+		b2db.RocksDbWriteConn = nil
 	}
+	b2db.indexMu.Lock()
+	b2db.commitLocks = nil
+	b2db.rebuilds = nil
+	b2db.indexMu.Unlock()
 }
@@ -0,0 +1,112 @@
+package b2schema
+
+import (
+	"log"
+
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+// DeleteByKey 按行键删除一行的全部字段，并在事务提交后从ID索引和字段索引中移除该行
+// 行不存在时返回ErrRowNotFound
+func (t *B2Table) DeleteByKey(db *B2Database, rowKey string) error {
+	n, err := t.deleteRows(db, []string{rowKey}, nil)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRowNotFound
+	}
+	return nil
+}
+
+// DeleteWhere 删除表中所有满足match条件的行，所有行在同一个rocksdb事务中删除，返回删除的行数
+// match收到的是完整的一行数据，在删除前还会对锁定后的最新数据再判断一次
+func (t *B2Table) DeleteWhere(db *B2Database, match func(Row) bool) (int, error) {
+	var rowKeys []string
+	err := t.ScanFunc(db, ScanOptions{}, func(row Row) bool {
+		if match(row) {
+			rowKeys = append(rowKeys, row.Key)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(rowKeys) == 0 {
+		return 0, nil
+	}
+	return t.deleteRows(db, rowKeys, match)
+}
+
+// deleteRows 在一个事务中删除给定行键的全部字段，已经不存在或不再满足match的行会被跳过
+func (t *B2Table) deleteRows(db *B2Database, rowKeys []string, match func(Row) bool) (int, error) {
+	wopts := rdb.NewDefaultWriteOptions()
+	topts := rdb.NewDefaultTransactionOptions()
+	txn := db.RocksDbWriteConn.TransactionBegin(wopts, topts, nil)
+	defer txn.Destroy()
+	ops := newIndexOps(t)
+	chunks := t.txnChunkReader(txn)
+	deleted := 0
+	for _, rowKey := range rowKeys {
+		encoded, err := t.lockRow(txn, rowKey)
+		if err == ErrRowNotFound {
+			continue
+		}
+		if err != nil {
+			_ = txn.Rollback()
+			return 0, err
+		}
+		if match != nil {
+			values, err := t.decodeRow(encoded)
+			if err == nil {
+				err = chunks.fill(rowKey, values, nil)
+			}
+			if err != nil {
+				_ = txn.Rollback()
+				return 0, err
+			}
+			if !match(Row{Key: rowKey, Values: values}) {
+				continue
+			}
+		}
+		for i := range t.Columns {
+			col := &t.Columns[i]
+			err = txn.Delete(rowColumnKey(rowKey, col.ColumnID))
+			if err == nil {
+				// 修改过的点可能同时有单独存储的值和压缩块中的旧值
+				err = chunks.removePoint(col, rowKey)
+			}
+			if err != nil {
+				log.Printf("删除表 %s 行 %s 字段 %s 时发生错误: %v\n", t.TableName, rowKey, col.ColumnName, err)
+				_ = txn.Rollback()
+				return 0, err
+			}
+		}
+		ops.deleteRow(rowKey, encoded)
+		deleted++
+	}
+	if err := ops.commit(txn, db); err != nil {
+		log.Printf("提交事务时发生错误: %v\n", err)
+		_ = txn.Rollback()
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// decodeRow 将编码后的一行数据（字段名称->字节数组）解码为字段名称->值
+func (t *B2Table) decodeRow(encoded map[string][]byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(encoded))
+	for _, col := range t.Columns {
+		bs, ok := encoded[col.ColumnName]
+		if !ok {
+			continue
+		}
+		m, err := col.ParseMap(bs)
+		if err != nil {
+			log.Printf("解析表 %s 字段 %s 时发生错误: %v\n", t.TableName, col.ColumnName, err)
+			return nil, err
+		}
+		values[col.ColumnName] = m[col.ColumnName]
+	}
+	return values, nil
+}
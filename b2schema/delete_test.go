@@ -0,0 +1,48 @@
+package b2schema
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeleteRows(t *testing.T) {
+	b2db := openTestDatabase(t, "deleteDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	cols := make([]B2Column, 3)
+	cols[0] = *NewColumn("ts", "timestamp")
+	cols[1] = *NewColumn("host", "string").Length(32)
+	cols[2] = *NewColumn("temperature", "float64")
+	table, err := NewTimeSeriesTable("temperatures", cols, "ts", "host", b2db, meta)
+	if err != nil {
+		t.Fatalf("creating temperatures failed: %v", err)
+	}
+	base := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for minute := 0; minute < 4; minute++ {
+		host := []string{"web1", "web2"}[minute%2]
+		if _, err = table.InsertByValues(b2db, base.Add(time.Duration(minute)*time.Minute), host, float64(minute)); err != nil {
+			t.Fatalf("inserting into temperatures failed: %v", err)
+		}
+	}
+	n, err := table.DeleteWhere(b2db, func(row Row) bool {
+		return row.Values["host"] == "web2"
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 web2 rows deleted, got %d, %v", n, err)
+	}
+	rows, _, err := table.Scan(b2db, ScanOptions{})
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("expected 2 rows left, got %v, %v", rows, err)
+	}
+	if err = table.DeleteByKey(b2db, rows[0].Key); err != nil {
+		t.Fatalf("deleting row %s failed: %v", rows[0].Key, err)
+	}
+	if _, err = table.GetRow(b2db, rows[0].Key); err != ErrRowNotFound {
+		t.Errorf("deleted row is still readable: %v", err)
+	}
+	if err = table.DeleteByKey(b2db, rows[0].Key); err != ErrRowNotFound {
+		t.Errorf("expected ErrRowNotFound for a deleted row, got %v", err)
+	}
+	if n := b2db.Indexes.IDIndexLen(table.TableID); n != 1 {
+		t.Errorf("expected 1 row ID left in index, got %d", n)
+	}
+}
@@ -0,0 +1,256 @@
+package b2schema
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"sync"
+
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+// 字典编码: 字典编码字段中的每个不同的字符串分配一个从0开始递增的ID，行中只存储uvarint编码的ID
+// 字典保存在数据库rocksdb中，键为 dictPrefix + ColumnID + "/" + 字符串，值为uvarint(ID)
+// 清空表时字典一起清除，但ID不会重新从0开始: 下一个ID保存在 dictNextPrefix + ColumnID 中，
+// 清空期间写入的行引用的旧ID不会被解码成别的字符串
+// 打开数据库后第一次用到某个字段的字典时整个读入内存
+// 新字符串的ID先在内存中预留，字典条目与使用它的行在同一个事务中写入，被拒绝或回滚的行不会留下字典条目
+
+// dictPrefix 字典在数据库rocksdb中的键前缀
+const dictPrefix = "\x00dict/"
+
+// dictNextPrefix 清空表后字典下一个ID在数据库rocksdb中的键前缀，值为uvarint(ID)
+const dictNextPrefix = "\x00dictnext/"
+
+var (
+	// errNoDictionary 字段的字典没有加载，表META不是从打开的数据库中取得的
+	errNoDictionary = errors.New("dictionary is not loaded")
+	// errUnknownDictID 字典中没有这个ID
+	errUnknownDictID = errors.New("unknown dictionary id")
+)
+
+// Dict 设置字段是否使用字典编码，只适用于取值个数不多的string字段
+func (col *B2Column) Dict(d bool) *B2Column {
+	col.Dictionary = d
+	return col
+}
+
+// dictColumnPrefix 一个字段的字典键前缀
+func dictColumnPrefix(columnID string) []byte {
+	return []byte(dictPrefix + columnID + "/")
+}
+
+// dictNextKey 一个字段的字典下一个ID的键
+func dictNextKey(columnID string) []byte {
+	return []byte(dictNextPrefix + columnID)
+}
+
+// dictionary 一个字典编码字段的字符串与ID的双向映射
+type dictionary struct {
+	db       *B2Database
+	columnID string
+	mu       sync.RWMutex
+	ids      map[string]uint64
+	values   map[uint64]string
+	// next 下一个分配的ID，只增不减
+	next uint64
+	// pending 已经预留、还没有随行数据提交的ID
+	pending map[uint64]bool
+}
+
+// dictionary 返回字段的字典，第一次使用时从rocksdb读入
+func (b2db *B2Database) dictionary(columnID string) (*dictionary, error) {
+	b2db.dictMu.Lock()
+	defer b2db.dictMu.Unlock()
+	if d, ok := b2db.dicts[columnID]; ok {
+		return d, nil
+	}
+	d := &dictionary{db: b2db, columnID: columnID, ids: make(map[string]uint64),
+		values: make(map[uint64]string), pending: make(map[uint64]bool)}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	if b2db.dicts == nil {
+		b2db.dicts = make(map[string]*dictionary)
+	}
+	b2db.dicts[columnID] = d
+	return d, nil
+}
+
+// attachDictionaries 为表中字典编码的字段关联字典，数据库没有打开时不关联
+func (b2db *B2Database) attachDictionaries(table *B2Table) error {
+	if b2db.RocksDbWriteConn == nil {
+		return nil
+	}
+	for i := range table.Columns {
+		col := &table.Columns[i]
+		if !col.Dictionary {
+			continue
+		}
+		d, err := b2db.dictionary(col.ColumnID)
+		if err != nil {
+			log.Printf("读取表 %s 字段 %s 的字典时发生错误: %v\n", table.TableName, col.ColumnName, err)
+			return err
+		}
+		col.dict = d
+	}
+	return nil
+}
+
+// resetDictionaries 清空表中字典编码字段的内存字典，在表数据和字典一起清除后调用
+// 清空表时保留下一个ID，删除表时丢弃整个字典
+func (b2db *B2Database) resetDictionaries(table *B2Table, drop bool) {
+	b2db.dictMu.Lock()
+	defer b2db.dictMu.Unlock()
+	for _, col := range table.Columns {
+		if d, ok := b2db.dicts[col.ColumnID]; ok {
+			d.mu.Lock()
+			d.ids = make(map[string]uint64)
+			d.values = make(map[uint64]string)
+			d.pending = make(map[uint64]bool)
+			d.mu.Unlock()
+			if drop {
+				delete(b2db.dicts, col.ColumnID)
+			}
+		}
+	}
+}
+
+// saveDictionaryNext 清除表数据前同步写入字典编码字段的下一个ID
+func (b2db *B2Database) saveDictionaryNext(table *B2Table) error {
+	wopts := rdb.NewDefaultWriteOptions()
+	wopts.SetSync(true)
+	for _, col := range table.Columns {
+		if !col.Dictionary {
+			continue
+		}
+		d, err := b2db.dictionary(col.ColumnID)
+		if err != nil {
+			return err
+		}
+		d.mu.RLock()
+		next := d.next
+		d.mu.RUnlock()
+		if err = b2db.RocksDbWriteConn.Put(wopts, dictNextKey(col.ColumnID), uvarintBytes(next)); err != nil {
+			log.Printf("写入字典 %s 的下一个ID时发生错误: %v\n", col.ColumnID, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// load 从rocksdb读入字典
+func (d *dictionary) load() error {
+	opts := rdb.NewDefaultReadOptions()
+	slice, err := d.db.RocksDbWriteConn.Get(opts, dictNextKey(d.columnID))
+	if err != nil {
+		log.Printf("读取字典 %s 的下一个ID时发生错误: %v\n", d.columnID, err)
+		return err
+	}
+	if slice.Size() > 0 {
+		d.next, _ = binary.Uvarint(slice.Data())
+	}
+	slice.Free()
+	it, release := d.db.newIterator(opts)
+	defer release()
+	prefix := dictColumnPrefix(d.columnID)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		key := it.Key()
+		value := it.Value()
+		s := string(key.Data()[len(prefix):])
+		id, n := binary.Uvarint(value.Data())
+		key.Free()
+		value.Free()
+		if n <= 0 {
+			log.Printf("字典 %s 中 %q 的ID无法解析\n", d.columnID, s)
+			return errUnknownDictID
+		}
+		d.ids[s] = id
+		d.values[id] = s
+		if id >= d.next {
+			d.next = id + 1
+		}
+	}
+	return it.Err()
+}
+
+// encode 返回字符串的ID编码，字符串不在字典中时allocate为true则预留新ID，否则返回false
+// 预留的ID由writePending随使用它的行写入rocksdb
+func (d *dictionary) encode(s string, allocate bool) ([]byte, bool, error) {
+	d.mu.RLock()
+	id, ok := d.ids[s]
+	d.mu.RUnlock()
+	if !ok && allocate {
+		d.mu.Lock()
+		if id, ok = d.ids[s]; !ok {
+			id = d.next
+			d.next++
+			d.ids[s] = id
+			d.values[id] = s
+			d.pending[id] = true
+			ok = true
+		}
+		d.mu.Unlock()
+	}
+	if !ok {
+		return nil, false, nil
+	}
+	return uvarintBytes(id), true, nil
+}
+
+// writePending ID还没有写入rocksdb时在事务中写入字典条目
+// 同一个ID可能被几个并发的事务同时写入，写入的内容相同
+func (d *dictionary) writePending(txn *rdb.Transaction, id uint64) error {
+	d.mu.RLock()
+	pending := d.pending[id]
+	s := d.values[id]
+	d.mu.RUnlock()
+	if !pending {
+		return nil
+	}
+	if err := txn.Put(append(dictColumnPrefix(d.columnID), s...), uvarintBytes(id)); err != nil {
+		log.Printf("写入字典 %s 时发生错误: %v\n", d.columnID, err)
+		return err
+	}
+	return nil
+}
+
+// committed 写入字典条目的事务提交后调用，ID不再需要写入
+func (d *dictionary) committed(id uint64) {
+	d.mu.Lock()
+	delete(d.pending, id)
+	d.mu.Unlock()
+}
+
+// decode 将ID编码还原为字符串
+func (d *dictionary) decode(bs []byte) (string, error) {
+	id, n := binary.Uvarint(bs)
+	if n <= 0 {
+		return "", errUnknownDictID
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	s, ok := d.values[id]
+	if !ok {
+		return "", errUnknownDictID
+	}
+	return s, nil
+}
+
+// uvarintBytes 将ID编码为uvarint字节数组
+func uvarintBytes(id uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutUvarint(buf, id)]
+}
+
+// lookupBytes 按字段类型编码查询值，字典编码字段不为查询值分配新ID，字典中没有这个值时返回false
+func (col *B2Column) lookupBytes(value interface{}) ([]byte, bool, error) {
+	if s, ok := value.(string); ok && col.Dictionary {
+		if col.dict == nil {
+			return nil, false, errNoDictionary
+		}
+		return col.dict.encode(s, false)
+	}
+	bs, err := col.FormatBytes(value)
+	return bs, err == nil, err
+}
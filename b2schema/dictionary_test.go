@@ -0,0 +1,96 @@
+package b2schema
+
+import (
+	"testing"
+	"time"
+
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+func TestDictionary(t *testing.T) {
+	cols := make([]B2Column, 4)
+	cols[0] = *NewColumn("ts", "timestamp")
+	cols[1] = *NewColumn("host", "string").Length(32).Dict(true).Index(true)
+	cols[2] = *NewColumn("region", "string").Length(16).Dict(true).DefaultValue("eu-west")
+	cols[3] = *NewColumn("cpu", "float64")
+	b2db := openTestDatabase(t, "dictionaryDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	table, err := NewTimeSeriesTable("hosts", cols, "ts", "host", b2db, meta)
+	if err != nil {
+		t.Fatalf("creating hosts failed: %v", err)
+	}
+	base := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	var keys []string
+	for i := 0; i < 6; i++ {
+		host := []string{"web-server-01", "web-server-02", "db-server-01"}[i%3]
+		key, err := table.InsertByValues(b2db, base.Add(time.Duration(i)*time.Minute), host, nil, float64(i))
+		if err != nil {
+			t.Fatalf("inserting into hosts failed: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	slice, err := b2db.RocksDbWriteConn.Get(rdb.NewDefaultReadOptions(), rowColumnKey(keys[5], table.column("host").ColumnID))
+	if err != nil || slice.Size() != 1 {
+		t.Errorf("dictionary encoded value should take 1 byte, got %v, %v", slice.Data(), err)
+	}
+	slice.Free()
+	// 重新读入字典后仍然可以解码
+	b2db.dicts = make(map[string]*dictionary)
+	if table, err = b2db.GetTable("hosts", meta); err != nil {
+		t.Fatal("get hosts META failed")
+	}
+	row, err := table.GetRow(b2db, keys[5])
+	if err != nil || row["host"] != "db-server-01" || row["region"] != "eu-west" {
+		t.Errorf("unexpected dictionary decoded row: %v, %v", row, err)
+	}
+	if s, ok := table.column("host").ParseString([]byte{1}); !ok || s != "web-server-02" {
+		t.Errorf("ParseString should decode dictionary IDs, got %q", s)
+	}
+	found, err := table.LookupByIndex(b2db, "host", "web-server-02")
+	if err != nil || len(found) != 2 {
+		t.Errorf("lookup on a dictionary encoded column failed: %v, %v", found, err)
+	}
+	rows, err := table.QuerySeriesTimeRange(b2db, "web-server-01", base, base.Add(time.Hour))
+	if err != nil || len(rows) != 2 || rows[1].Values["cpu"] != 3.0 {
+		t.Errorf("unexpected series time range result: %v, %v", rows, err)
+	}
+	if rows, err = table.QuerySeriesTimeRange(b2db, "no-such-host", base, base.Add(time.Hour)); err != nil || len(rows) != 0 {
+		t.Errorf("querying an unknown series should return nothing: %v, %v", rows, err)
+	}
+	if len(table.column("host").dict.values) != 3 {
+		t.Errorf("querying should not add values to the dictionary: %v", table.column("host").dict.values)
+	}
+	bad := []B2Column{*NewColumn("age", "int32").Dict(true)}
+	if _, err = NewTable("badDict", bad, b2db, meta); err == nil {
+		t.Error("dictionary encoding should only be accepted on string columns")
+	}
+}
+
+func TestDictionaryRejectedRows(t *testing.T) {
+	cols := make([]B2Column, 2)
+	cols[0] = *NewColumn("host", "string").Length(32).Dict(true)
+	cols[1] = *NewColumn("cpu", "float64")
+	b2db := openTestDatabase(t, "dictRejectDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	table, err := NewTable("hosts", cols, b2db, meta)
+	if err != nil {
+		t.Fatalf("creating hosts failed: %v", err)
+	}
+	stored := func(s string) bool {
+		slice, err := b2db.RocksDbWriteConn.Get(rdb.NewDefaultReadOptions(), append(dictColumnPrefix(table.column("host").ColumnID), s...))
+		defer slice.Free()
+		return err == nil && slice.Exists()
+	}
+	if _, err = table.InsertByValues(b2db, "rejected-host", "not a number"); err == nil {
+		t.Fatal("inserting a string into a float64 column should fail")
+	}
+	if stored("rejected-host") {
+		t.Error("a rejected row should not leave a dictionary entry")
+	}
+	if _, err = table.InsertByValues(b2db, "rejected-host", 1.5); err != nil {
+		t.Fatalf("inserting into hosts failed: %v", err)
+	}
+	if !stored("rejected-host") {
+		t.Error("the dictionary entry should be written with the row")
+	}
+}
@@ -0,0 +1,39 @@
+package b2schema
+
+import (
+	"testing"
+)
+
+// openTestDatabase 为一个测试新建并打开独立的数据库，测试结束时用dropTestDatabase删除
+func openTestDatabase(t *testing.T, name string) *B2Database {
+	b2db, err := NewDatabaseAndOpen(name, meta)
+	if err != nil {
+		t.Fatalf("creating %s failed: %v", name, err)
+	}
+	return b2db
+}
+
+// dropTestDatabase 关闭并删除openTestDatabase新建的数据库
+func dropTestDatabase(t *testing.T, b2db *B2Database) {
+	b2db.Close()
+	if err := DropDatabase(b2db.Database, meta); err != nil {
+		t.Errorf("dropping %s failed: %v", b2db.Database, err)
+	}
+}
+
+// newUsersTable 在数据库中新建users表: 建有索引的username和age，按顺序写入names中的用户，age从30开始递增
+func newUsersTable(t *testing.T, b2db *B2Database, names ...string) *B2Table {
+	cols := make([]B2Column, 2)
+	cols[0] = *NewColumn("username", "string").Length(100).Index(true)
+	cols[1] = *NewColumn("age", "int32")
+	table, err := NewTable("users", cols, b2db, meta)
+	if err != nil {
+		t.Fatalf("creating users failed: %v", err)
+	}
+	for i, name := range names {
+		if _, err = table.InsertByValues(b2db, name, int32(30+i)); err != nil {
+			t.Fatalf("inserting %s into users failed: %v", name, err)
+		}
+	}
+	return table
+}
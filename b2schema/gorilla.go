@@ -0,0 +1,266 @@
+package b2schema
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// 压缩块编码，参考Facebook Gorilla论文:
+// 块头为uvarint(点数)，随后是位流
+// 第一个点的时间戳和值各占64位，之后的时间戳写delta-of-delta，值写与前一个值的XOR
+//
+// delta-of-delta编码:
+//   0                  等于0
+//   10    + 7位        [-63, 64]
+//   110   + 9位        [-255, 256]
+//   1110  + 12位       [-2047, 2048]
+//   11110 + 32位       [-(2^31-1), 2^31]
+//   11111 + 64位       其他
+//
+// XOR编码:
+//   0                                  与前一个值相同
+//   10 + 有效位                         有效位落在前一个值的前导零/尾随零窗口内
+//   11 + 5位前导零 + 6位有效位长度-1 + 有效位
+
+// xorWindowHeader 新窗口头部的位数: 控制位 + 5位前导零 + 6位长度
+const xorWindowHeader = 1 + 5 + 6
+
+var errCorruptChunk = errors.New("corrupt chunk")
+
+// bitWriter 按位写入的缓冲区
+type bitWriter struct {
+	buf   []byte
+	count uint8 // 最后一个字节中剩余可写的位数
+}
+
+// writeBit 写入一位
+func (w *bitWriter) writeBit(bit bool) {
+	if w.count == 0 {
+		w.buf = append(w.buf, 0)
+		w.count = 8
+	}
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << (w.count - 1)
+	}
+	w.count--
+}
+
+// writeBits 写入value的低n位，高位在前
+func (w *bitWriter) writeBits(value uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.writeBit(value&(1<<uint(i)) != 0)
+	}
+}
+
+// bitReader 按位读取
+type bitReader struct {
+	buf   []byte
+	pos   int   // 当前字节位置
+	count uint8 // 当前字节中剩余可读的位数
+}
+
+func newBitReader(buf []byte) *bitReader {
+	return &bitReader{buf: buf, count: 8}
+}
+
+// readBit 读取一位
+func (r *bitReader) readBit() (bool, error) {
+	if r.count == 0 {
+		r.pos++
+		r.count = 8
+	}
+	if r.pos >= len(r.buf) {
+		return false, errCorruptChunk
+	}
+	r.count--
+	return r.buf[r.pos]&(1<<r.count) != 0, nil
+}
+
+// readBits 读取n位，高位在前
+func (r *bitReader) readBits(n int) (uint64, error) {
+	var value uint64
+	for i := 0; i < n; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		value <<= 1
+		if bit {
+			value |= 1
+		}
+	}
+	return value, nil
+}
+
+// dodBuckets delta-of-delta的分段: 控制位个数与数据位个数，最后一段固定为64位
+var dodBuckets = []struct {
+	prefix int // 前缀中1的个数
+	width  int // 数据位数
+}{
+	{1, 7},
+	{2, 9},
+	{3, 12},
+	{4, 32},
+}
+
+// encodeChunk 将一组按时间升序排列的点编码为一个压缩块，values为值的64位表示
+func encodeChunk(ts []int64, values []uint64) []byte {
+	head := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(head, uint64(len(ts)))
+	w := &bitWriter{buf: head[:n]}
+	if len(ts) == 0 {
+		return w.buf
+	}
+	w.writeBits(uint64(ts[0]), 64)
+	w.writeBits(values[0], 64)
+	var prevDelta int64
+	prevValue := values[0]
+	leading, trailing := -1, 0
+	for i := 1; i < len(ts); i++ {
+		delta := ts[i] - ts[i-1]
+		writeDoD(w, delta-prevDelta)
+		prevDelta = delta
+
+		xor := values[i] ^ prevValue
+		prevValue = values[i]
+		if xor == 0 {
+			w.writeBit(false)
+			continue
+		}
+		w.writeBit(true)
+		lz, tz := bits.LeadingZeros64(xor), bits.TrailingZeros64(xor)
+		if lz > 31 {
+			// 前导零个数只有5位
+			lz = 31
+		}
+		// 沿用窗口浪费的位数超过新窗口头部的长度时改用新窗口，避免一次异常值之后一直使用很宽的窗口
+		if leading >= 0 && lz >= leading && tz >= trailing && lz-leading+tz-trailing <= xorWindowHeader {
+			w.writeBit(false)
+			w.writeBits(xor>>uint(trailing), 64-leading-trailing)
+			continue
+		}
+		leading, trailing = lz, tz
+		w.writeBit(true)
+		w.writeBits(uint64(leading), 5)
+		w.writeBits(uint64(64-leading-trailing-1), 6)
+		w.writeBits(xor>>uint(trailing), 64-leading-trailing)
+	}
+	return w.buf
+}
+
+// writeDoD 写入一个delta-of-delta值
+func writeDoD(w *bitWriter, dod int64) {
+	if dod == 0 {
+		w.writeBit(false)
+		return
+	}
+	for _, b := range dodBuckets {
+		lo, hi := -(int64(1)<<uint(b.width-1))+1, int64(1)<<uint(b.width-1)
+		if dod >= lo && dod <= hi {
+			w.writeBits((1<<uint(b.prefix)-1)<<1, b.prefix+1)
+			// 区间向右偏移一位，使hi可以用width位表示
+			w.writeBits(uint64(dod-1)&(1<<uint(b.width)-1), b.width)
+			return
+		}
+	}
+	w.writeBits(1<<5-1, 5)
+	w.writeBits(uint64(dod), 64)
+}
+
+// readDoD 读取一个delta-of-delta值
+func readDoD(r *bitReader) (int64, error) {
+	ones := 0
+	for ones < len(dodBuckets)+1 {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		ones++
+	}
+	if ones == 0 {
+		return 0, nil
+	}
+	if ones > len(dodBuckets) {
+		v, err := r.readBits(64)
+		return int64(v), err
+	}
+	width := dodBuckets[ones-1].width
+	v, err := r.readBits(width)
+	if err != nil {
+		return 0, err
+	}
+	// 按width位符号扩展后还原偏移
+	shift := uint(64 - width)
+	return int64(v<<shift)>>shift + 1, nil
+}
+
+// decodeChunk encodeChunk的逆操作
+func decodeChunk(chunk []byte) ([]int64, []uint64, error) {
+	count, n := binary.Uvarint(chunk)
+	if n <= 0 || count > uint64(len(chunk))*8 {
+		return nil, nil, errCorruptChunk
+	}
+	ts := make([]int64, 0, count)
+	values := make([]uint64, 0, count)
+	if count == 0 {
+		return ts, values, nil
+	}
+	r := newBitReader(chunk[n:])
+	first, err := r.readBits(64)
+	if err != nil {
+		return nil, nil, err
+	}
+	value, err := r.readBits(64)
+	if err != nil {
+		return nil, nil, err
+	}
+	ts = append(ts, int64(first))
+	values = append(values, value)
+	var delta int64
+	leading, trailing := 0, 0
+	for i := uint64(1); i < count; i++ {
+		dod, err := readDoD(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		delta += dod
+		ts = append(ts, ts[len(ts)-1]+delta)
+
+		changed, err := r.readBit()
+		if err != nil {
+			return nil, nil, err
+		}
+		if changed {
+			newWindow, err := r.readBit()
+			if err != nil {
+				return nil, nil, err
+			}
+			if newWindow {
+				lz, err := r.readBits(5)
+				if err != nil {
+					return nil, nil, err
+				}
+				length, err := r.readBits(6)
+				if err != nil {
+					return nil, nil, err
+				}
+				leading = int(lz)
+				trailing = 64 - leading - int(length) - 1
+				if trailing < 0 {
+					return nil, nil, errCorruptChunk
+				}
+			}
+			xor, err := r.readBits(64 - leading - trailing)
+			if err != nil {
+				return nil, nil, err
+			}
+			value ^= xor << uint(trailing)
+		}
+		values = append(values, value)
+	}
+	return ts, values, nil
+}
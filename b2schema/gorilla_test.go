@@ -0,0 +1,53 @@
+package b2schema
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestChunkRoundTrip(t *testing.T) {
+	var ts []int64
+	var values []uint64
+	now := int64(1546300800000000000)
+	for i := 0; i < 1000; i++ {
+		// 大部分点间隔固定，夹杂少量抖动和大跨度
+		now += 10e9
+		switch {
+		case i%50 == 7:
+			now += 37
+		case i%200 == 9:
+			now += 3600e9
+		}
+		ts = append(ts, now)
+		values = append(values, math.Float64bits(20+float64(i%7)/4))
+	}
+	values[500] = math.Float64bits(math.NaN())
+	values[501] = math.Float64bits(-1e300)
+	values[502] = uint64(math.MaxUint64)
+	chunk := encodeChunk(ts, values)
+	if len(chunk) > len(ts)*16/5 {
+		t.Errorf("chunk of %d points takes %d bytes", len(ts), len(chunk))
+	}
+	gotTs, gotValues, err := decodeChunk(chunk)
+	if err != nil {
+		t.Fatalf("decoding chunk failed: %v", err)
+	}
+	if !reflect.DeepEqual(gotTs, ts) || !reflect.DeepEqual(gotValues, values) {
+		t.Error("chunk round trip mismatched")
+	}
+	if _, _, err = decodeChunk(chunk[:len(chunk)/2]); err == nil {
+		t.Error("a truncated chunk should not be decoded")
+	}
+}
+
+func TestDeltaOfDelta(t *testing.T) {
+	for _, dod := range []int64{0, 1, -1, 64, -63, 65, -64, 256, -255, 2048, -2047, 1 << 31, -(1<<31 - 1), 1 << 40, math.MinInt64, math.MaxInt64} {
+		w := &bitWriter{}
+		writeDoD(w, dod)
+		got, err := readDoD(newBitReader(w.buf))
+		if err != nil || got != dod {
+			t.Errorf("delta of delta %d: got %d, %v", dod, got, err)
+		}
+	}
+}
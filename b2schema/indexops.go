@@ -0,0 +1,200 @@
+package b2schema
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/babydb/babydb/core"
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+// indexOps 一个事务中累积的索引变更，只有在事务提交成功后才调用apply应用到内存索引
+// 事务回滚时直接丢弃即可，索引不会受到影响
+// 写入的行用到的字典ID也记录在这里，提交时把新预留的字典条目写入同一个事务
+type indexOps struct {
+	table   *B2Table
+	inserts []indexedRow
+	deletes []indexedRow
+	updates []indexedUpdate
+	dictIDs []dictID
+}
+
+// dictID 写入的行用到的一个字典ID
+type dictID struct {
+	dict *dictionary
+	id   uint64
+}
+
+// indexedRow 参与索引的一行: 行键以及建有索引的字段值（字段名称->值）
+type indexedRow struct {
+	rowKey string
+	values map[string]interface{}
+}
+
+// indexedUpdate 修改一行时字段索引的变化: 修改前后发生变化的索引字段值
+type indexedUpdate struct {
+	rowKey string
+	before map[string]interface{}
+	after  map[string]interface{}
+}
+
+func newIndexOps(t *B2Table) *indexOps {
+	return &indexOps{table: t}
+}
+
+// commitLock 返回表的提交锁
+func (b2db *B2Database) commitLock(tableID string) *sync.Mutex {
+	b2db.indexMu.Lock()
+	defer b2db.indexMu.Unlock()
+	if b2db.commitLocks == nil {
+		b2db.commitLocks = make(map[string]*sync.Mutex)
+	}
+	mu, ok := b2db.commitLocks[tableID]
+	if !ok {
+		mu = &sync.Mutex{}
+		b2db.commitLocks[tableID] = mu
+	}
+	return mu
+}
+
+// forgetTable 丢弃已删除表的提交锁和重建日志
+func (b2db *B2Database) forgetTable(tableID string) {
+	b2db.indexMu.Lock()
+	defer b2db.indexMu.Unlock()
+	delete(b2db.commitLocks, tableID)
+	delete(b2db.rebuilds, tableID)
+}
+
+// lockCommits 锁定多张表的提交，返回解锁函数，锁定期间这些表的索引不会变化
+func (b2db *B2Database) lockCommits(tables []*B2Table) func() {
+	locks := make([]*sync.Mutex, 0, len(tables))
+	for _, t := range tables {
+		mu := b2db.commitLock(t.TableID)
+		mu.Lock()
+		locks = append(locks, mu)
+	}
+	return func() {
+		for _, mu := range locks {
+			mu.Unlock()
+		}
+	}
+}
+
+// commit 提交事务并应用累积的索引变更
+// 同一张表的提交和应用在表的提交锁内串行进行，索引变更的应用顺序与事务提交顺序一致，
+// 先后修改同一行的两个事务不会因为应用顺序颠倒而在索引中留下旧值
+func (ops *indexOps) commit(txn *rdb.Transaction, db *B2Database) error {
+	for _, ref := range ops.dictIDs {
+		if err := ref.dict.writePending(txn, ref.id); err != nil {
+			return err
+		}
+	}
+	mu := db.commitLock(ops.table.TableID)
+	mu.Lock()
+	defer mu.Unlock()
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	for _, ref := range ops.dictIDs {
+		ref.dict.committed(ref.id)
+	}
+	ops.apply(db)
+	return nil
+}
+
+// insertRow 记录写入一行，encoded为已经编码的字段值（字段名称->字节数组）
+func (ops *indexOps) insertRow(rowKey string, encoded map[string][]byte) {
+	ops.useDictionaries(encoded)
+	ops.inserts = append(ops.inserts, indexedRow{rowKey: rowKey, values: ops.table.indexedValues(encoded)})
+}
+
+// deleteRow 记录删除一行，encoded为该行删除前的字段值
+func (ops *indexOps) deleteRow(rowKey string, encoded map[string][]byte) {
+	ops.deletes = append(ops.deletes, indexedRow{rowKey: rowKey, values: ops.table.indexedValues(encoded)})
+}
+
+// updateRow 记录修改一行，before和after为修改前后值发生变化的字段，行ID不变
+func (ops *indexOps) updateRow(rowKey string, before, after map[string][]byte) {
+	ops.useDictionaries(after)
+	ops.updates = append(ops.updates, indexedUpdate{
+		rowKey: rowKey,
+		before: ops.table.indexedValues(before),
+		after:  ops.table.indexedValues(after),
+	})
+}
+
+// useDictionaries 记录写入的字段值中用到的字典ID
+func (ops *indexOps) useDictionaries(encoded map[string][]byte) {
+	for _, col := range ops.table.Columns {
+		value, ok := encoded[col.ColumnName]
+		if !ok || !col.Dictionary || col.dict == nil {
+			continue
+		}
+		if id, n := binary.Uvarint(value); n > 0 {
+			ops.dictIDs = append(ops.dictIDs, dictID{dict: col.dict, id: id})
+		}
+	}
+}
+
+// apply 将累积的索引变更应用到数据库的ID索引和各字段索引，先删除后插入
+// 表的索引正在重建时同时记录到重建日志，重建完成时回放到新索引上
+func (ops *indexOps) apply(db *B2Database) {
+	m := db.Indexes
+	if journal := db.activeRebuild(ops.table.TableID); journal != nil {
+		journal.mu.Lock()
+		defer journal.mu.Unlock()
+		journal.ops = append(journal.ops, ops)
+	}
+	for _, row := range ops.deletes {
+		core.IDIndex(row.rowKey).DeleteOpIndexing(m, ops.table.TableID)
+		ops.table.eachIndex(row.values, func(indexID string, value interface{}) {
+			core.NormalIndex{Value: value, UID: []string{row.rowKey}}.DeleteOpIndexing(m, indexID)
+		})
+	}
+	for _, row := range ops.inserts {
+		core.IDIndex(row.rowKey).InsertOpIndexing(m, ops.table.TableID)
+		ops.table.eachIndex(row.values, func(indexID string, value interface{}) {
+			core.NormalIndex{Value: value, UID: []string{row.rowKey}}.InsertOpIndexing(m, indexID)
+		})
+	}
+	for _, u := range ops.updates {
+		ops.table.eachIndex(u.before, func(indexID string, value interface{}) {
+			core.NormalIndex{Value: value, UID: []string{u.rowKey}}.DeleteOpIndexing(m, indexID)
+		})
+		ops.table.eachIndex(u.after, func(indexID string, value interface{}) {
+			core.NormalIndex{Value: value, UID: []string{u.rowKey}}.InsertOpIndexing(m, indexID)
+		})
+	}
+}
+
+// indexedValues 从编码后的一行数据中解码出建有索引的字段值
+func (t *B2Table) indexedValues(encoded map[string][]byte) map[string]interface{} {
+	values := make(map[string]interface{})
+	for _, col := range t.Columns {
+		if !col.Indexing || len(col.IndexID) == 0 {
+			continue
+		}
+		bs, ok := encoded[col.ColumnName]
+		if !ok {
+			continue
+		}
+		m, err := col.ParseMap(bs)
+		if err != nil {
+			continue
+		}
+		values[col.ColumnName] = m[col.ColumnName]
+	}
+	return values
+}
+
+// eachIndex 对一行中每个建有索引且有值的字段调用fn
+func (t *B2Table) eachIndex(values map[string]interface{}, fn func(indexID string, value interface{})) {
+	for _, col := range t.Columns {
+		if !col.Indexing || len(col.IndexID) == 0 {
+			continue
+		}
+		if v, ok := values[col.ColumnName]; ok && v != nil {
+			fn(col.IndexID, v)
+		}
+	}
+}
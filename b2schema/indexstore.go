@@ -0,0 +1,181 @@
+package b2schema
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"time"
+
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+// indexSnapshotPrefix 索引快照在数据库rocksdb中的键前缀，以0x00开头，不会与表数据的键冲突
+const indexSnapshotPrefix = "\x00index/"
+
+// errNoMeta 数据库没有关联元数据库
+var errNoMeta = errors.New("database meta is not attached")
+
+// indexCleanKey 干净关闭标记，与索引快照在同一个事务中写入，打开数据库后立即删除
+// 只有这个标记存在时索引快照才与数据一致；进程异常退出后没有标记，打开时从数据重建全部索引
+const indexCleanKey = "\x00indexclean"
+
+// indexSnapshotKey 表ID或索引ID对应的索引快照键
+func indexSnapshotKey(id string) []byte {
+	return []byte(indexSnapshotPrefix + id)
+}
+
+// SaveIndexes 将数据库中所有表的ID索引和字段索引快照写入数据库的rocksdb
+// 保存期间持有各表的提交锁，各索引快照是同一时刻的。数据库打开期间保存的快照只有在Close时
+// 写入干净关闭标记后才会在下次打开时使用，Close会再次保存，一般不需要单独调用
+// 内存中已经没有的索引会删除旧快照
+func (b2db *B2Database) SaveIndexes(meta *MetaDBSource) error {
+	return b2db.saveIndexes(meta, false)
+}
+
+// saveIndexes 保存索引快照，clean为true时在同一个事务中写入干净关闭标记
+func (b2db *B2Database) saveIndexes(meta *MetaDBSource, clean bool) error {
+	tables := make([]*B2Table, 0, len(b2db.TableList))
+	for _, name := range b2db.TableList {
+		table, err := b2db.GetTable(name, meta)
+		if err == ErrTableFormat {
+			continue
+		}
+		if err != nil {
+			log.Printf("保存索引时读取表 %s 的META发生错误: %v\n", name, err)
+			return err
+		}
+		tables = append(tables, table)
+	}
+	unlock := b2db.lockCommits(tables)
+	defer unlock()
+	wopts := rdb.NewDefaultWriteOptions()
+	wopts.SetSync(true)
+	topts := rdb.NewDefaultTransactionOptions()
+	txn := b2db.RocksDbWriteConn.TransactionBegin(wopts, topts, nil)
+	defer txn.Destroy()
+	for _, table := range tables {
+		if err := saveSnapshot(txn, table.TableID, b2db.Indexes.IDIndexSnapshot); err != nil {
+			_ = txn.Rollback()
+			return err
+		}
+		for _, col := range table.Columns {
+			if !col.Indexing || len(col.IndexID) == 0 {
+				continue
+			}
+			if err := saveSnapshot(txn, col.IndexID, b2db.Indexes.NormalIndexSnapshot); err != nil {
+				_ = txn.Rollback()
+				return err
+			}
+		}
+	}
+	if clean {
+		if err := txn.Put([]byte(indexCleanKey), []byte(time.Now().Format(time.RFC3339Nano))); err != nil {
+			log.Printf("写入干净关闭标记时发生错误: %v\n", err)
+			_ = txn.Rollback()
+			return err
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		log.Printf("提交索引快照时发生错误: %v\n", err)
+		_ = txn.Rollback()
+		return err
+	}
+	return nil
+}
+
+func saveSnapshot(txn *rdb.Transaction, id string, snapshot func(string) ([]byte, error)) error {
+	data, err := snapshot(id)
+	if err != nil {
+		log.Printf("生成索引 %s 的快照时发生错误: %v\n", id, err)
+		return err
+	}
+	if data == nil {
+		return txn.Delete(indexSnapshotKey(id))
+	}
+	if err = txn.Put(indexSnapshotKey(id), data); err != nil {
+		log.Printf("写入索引 %s 的快照时发生错误: %v\n", id, err)
+		return err
+	}
+	return nil
+}
+
+// openIndexes 打开数据库时建立内存索引
+// 有干净关闭标记时还原索引快照，校验失败的快照所在的表从数据重建；
+// 没有标记时快照可能落后于数据，从数据重建全部表的索引
+// 随后删除标记，之后的写入使快照过期，直到下一次Close重新保存
+func (b2db *B2Database) openIndexes() error {
+	opts := rdb.NewDefaultReadOptions()
+	marker, err := b2db.RocksDbWriteConn.Get(opts, []byte(indexCleanKey))
+	if err != nil {
+		log.Printf("读取数据库 %s 的干净关闭标记时发生错误: %v\n", b2db.Database, err)
+		return err
+	}
+	clean := marker.Size() > 0
+	marker.Free()
+	if clean {
+		if err = b2db.loadIndexes(); err == nil && len(b2db.InvalidIndexes) > 0 {
+			log.Printf("数据库 %s 的索引快照 %v 校验失败，从数据重建\n", b2db.Database, b2db.InvalidIndexes)
+			err = b2db.RebuildInvalidIndexes(b2db.meta, nil)
+		}
+	} else {
+		log.Printf("数据库 %s 上次没有正常关闭，从数据重建索引\n", b2db.Database)
+		err = b2db.rebuildAll()
+	}
+	if err != nil {
+		return err
+	}
+	wopts := rdb.NewDefaultWriteOptions()
+	wopts.SetSync(true)
+	if err = b2db.RocksDbWriteConn.Delete(wopts, []byte(indexCleanKey)); err != nil {
+		log.Printf("删除数据库 %s 的干净关闭标记时发生错误: %v\n", b2db.Database, err)
+		return err
+	}
+	return nil
+}
+
+// rebuildAll 从数据重建数据库中全部表的索引
+func (b2db *B2Database) rebuildAll() error {
+	b2db.InvalidIndexes = nil
+	if b2db.meta == nil {
+		log.Printf("数据库 %s 不是从元数据库中取得的，无法读取表META重建索引\n", b2db.Database)
+		return errNoMeta
+	}
+	for _, name := range b2db.TableList {
+		table, err := b2db.GetTable(name, b2db.meta)
+		if err == ErrTableFormat {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = table.RebuildIndexes(b2db, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadIndexes 从数据库的rocksdb中还原所有索引快照，校验失败的快照ID记录在InvalidIndexes中
+func (b2db *B2Database) loadIndexes() error {
+	b2db.InvalidIndexes = nil
+	it, release := b2db.newIterator(rdb.NewDefaultReadOptions())
+	defer release()
+	prefix := []byte(indexSnapshotPrefix)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		key := it.Key()
+		id := string(bytes.TrimPrefix(key.Data(), prefix))
+		key.Free()
+		value := it.Value()
+		data := append([]byte(nil), value.Data()...)
+		value.Free()
+		if err := b2db.Indexes.LoadSnapshot(id, data); err != nil {
+			log.Printf("数据库 %s 的索引快照 %s 无法还原: %v\n", b2db.Database, id, err)
+			b2db.InvalidIndexes = append(b2db.InvalidIndexes, id)
+		}
+	}
+	if err := it.Err(); err != nil {
+		log.Printf("读取数据库 %s 的索引快照时发生错误: %v\n", b2db.Database, err)
+		return err
+	}
+	return nil
+}
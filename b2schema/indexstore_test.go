@@ -0,0 +1,100 @@
+package b2schema
+
+import (
+	"testing"
+
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+func TestNewDatabaseIsClean(t *testing.T) {
+	b2db, err := NewDatabase("freshDB", meta)
+	if err != nil {
+		t.Fatalf("creating freshDB failed: %v", err)
+	}
+	cfg := configOrDefault(meta.Config)
+	read, err := rdb.OpenDbForReadOnly(cfg.options(b2db.DatabaseID), cfg.path(b2db.DatabaseID), false)
+	if err != nil {
+		t.Fatalf("opening freshDB read only failed: %v", err)
+	}
+	marker, err := read.Get(rdb.NewDefaultReadOptions(), []byte(indexCleanKey))
+	if err != nil || marker.Size() == 0 {
+		t.Errorf("a new database should carry the clean shutdown marker: %v", err)
+	}
+	marker.Free()
+	read.Close()
+	if b2db, err = b2db.OpenConnection(meta.Config); err != nil {
+		t.Fatalf("opening freshDB failed: %v", err)
+	}
+	dropTestDatabase(t, b2db)
+}
+
+func TestReopenAfterUncleanShutdown(t *testing.T) {
+	b2db := openTestDatabase(t, "indexStoreDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	cols := []B2Column{*NewColumn("username", "string").Length(100).Index(true)}
+	table, err := NewTable("users", cols, b2db, meta)
+	if err != nil {
+		t.Fatalf("creating users failed: %v", err)
+	}
+	if _, err = table.InsertByValues(b2db, "alice"); err != nil {
+		t.Fatalf("inserting alice failed: %v", err)
+	}
+	// 正常关闭: 还原快照
+	b2db.Close()
+	if b2db, err = b2db.OpenConnection(meta.Config); err != nil {
+		t.Fatalf("reopening failed: %v", err)
+	}
+	if found, err := table.LookupByIndex(b2db, "username", "alice"); err != nil || len(found) != 1 {
+		t.Errorf("lookup after clean reopen failed: %v, %v", found, err)
+	}
+	// 保存快照后继续写入再异常退出: 快照已经过期，必须从数据重建
+	if err = b2db.SaveIndexes(meta); err != nil {
+		t.Fatalf("saving indexes failed: %v", err)
+	}
+	if _, err = table.InsertByValues(b2db, "bob"); err != nil {
+		t.Fatalf("inserting bob failed: %v", err)
+	}
+	b2db.closeConns()
+	if b2db, err = b2db.OpenConnection(meta.Config); err != nil {
+		t.Fatalf("reopening after crash failed: %v", err)
+	}
+	if n := b2db.Indexes.IDIndexLen(table.TableID); n != 2 {
+		t.Errorf("expected 2 row IDs after crash recovery, got %d", n)
+	}
+	if found, err := table.LookupByIndex(b2db, "username", "bob"); err != nil || len(found) != 1 {
+		t.Errorf("lookup after crash recovery failed: %v, %v", found, err)
+	}
+}
+
+func TestReopenWithCorruptSnapshot(t *testing.T) {
+	b2db := openTestDatabase(t, "corruptSnapshotDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	cols := []B2Column{*NewColumn("username", "string").Length(100).Index(true)}
+	table, err := NewTable("users", cols, b2db, meta)
+	if err != nil {
+		t.Fatalf("creating users failed: %v", err)
+	}
+	if _, err = table.InsertByValues(b2db, "alice"); err != nil {
+		t.Fatalf("inserting alice failed: %v", err)
+	}
+	b2db.Close()
+	cfg := configOrDefault(meta.Config)
+	conn, err := rdb.OpenTransactionDb(cfg.options(b2db.DatabaseID), rdb.NewDefaultTransactionDBOptions(), cfg.path(b2db.DatabaseID))
+	if err != nil {
+		t.Fatalf("opening raw connection failed: %v", err)
+	}
+	err = conn.Put(rdb.NewDefaultWriteOptions(), indexSnapshotKey(table.column("username").IndexID), []byte("garbage"))
+	conn.Close()
+	if err != nil {
+		t.Fatalf("corrupting snapshot failed: %v", err)
+	}
+	if b2db, err = b2db.OpenConnection(meta.Config); err != nil {
+		t.Fatalf("reopening failed: %v", err)
+	}
+	if len(b2db.InvalidIndexes) != 0 {
+		t.Errorf("invalid indexes should be rebuilt on open: %v", b2db.InvalidIndexes)
+	}
+	if found, err := table.LookupByIndex(b2db, "username", "alice"); err != nil || len(found) != 1 {
+		t.Errorf("lookup after rebuilding corrupt snapshot failed: %v, %v", found, err)
+	}
+}
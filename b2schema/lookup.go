@@ -0,0 +1,87 @@
+package b2schema
+
+import (
+	"errors"
+	"log"
+)
+
+// LookupByIndex 通过字段索引查找字段值等于value的行
+func (t *B2Table) LookupByIndex(db *B2Database, column string, value interface{}) ([]Row, error) {
+	col, err := t.indexedColumn(column)
+	if err != nil {
+		return nil, err
+	}
+	v, err := col.indexValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return t.fetchRows(db, db.Indexes.LookupEqual(col.IndexID, v))
+}
+
+// LookupRangeByIndex 通过字段索引查找字段值在 [lower, upper) 区间内的行，结果按字段值升序排列
+// lower或upper为nil表示该端不设限制
+func (t *B2Table) LookupRangeByIndex(db *B2Database, column string, lower, upper interface{}) ([]Row, error) {
+	col, err := t.indexedColumn(column)
+	if err != nil {
+		return nil, err
+	}
+	var lv, uv interface{}
+	if lower != nil {
+		if lv, err = col.indexValue(lower); err != nil {
+			return nil, err
+		}
+	}
+	if upper != nil {
+		if uv, err = col.indexValue(upper); err != nil {
+			return nil, err
+		}
+	}
+	return t.fetchRows(db, db.Indexes.LookupRange(col.IndexID, lv, uv))
+}
+
+// indexedColumn 按名称查找建有索引的字段
+func (t *B2Table) indexedColumn(name string) (*B2Column, error) {
+	col := t.column(name)
+	if col == nil {
+		log.Printf("表 %s 中不存在字段 %s\n", t.TableName, name)
+		return nil, errors.New("no such column")
+	}
+	if !col.Indexing || len(col.IndexID) == 0 {
+		log.Printf("表 %s 的字段 %s 没有建立索引\n", t.TableName, name)
+		return nil, errors.New("column is not indexed")
+	}
+	return col, nil
+}
+
+// indexValue 将查询值按字段类型规整为索引中保存的值类型
+// 字典编码字段的索引保存原始字符串，查询值不经过字典
+func (col *B2Column) indexValue(value interface{}) (interface{}, error) {
+	if s, ok := value.(string); ok && col.Dictionary {
+		return s, nil
+	}
+	bs, err := col.FormatBytes(value)
+	if err != nil {
+		return nil, err
+	}
+	m, err := col.ParseMap(bs)
+	if err != nil {
+		return nil, err
+	}
+	return m[col.ColumnName], nil
+}
+
+// fetchRows 按行键读取多行数据，索引中已经不存在的行会被忽略
+func (t *B2Table) fetchRows(db *B2Database, rowKeys []string) ([]Row, error) {
+	rows := make([]Row, 0, len(rowKeys))
+	for _, rowKey := range rowKeys {
+		values, err := t.GetRow(db, rowKey)
+		if err == ErrRowNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, Row{Key: rowKey, Values: values})
+	}
+	return rows, nil
+}
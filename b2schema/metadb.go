@@ -21,14 +21,21 @@ type MetaDBSource struct {
 	OpenTime time.Time
 	SyncTime time.Time
 	Mu       *sync.Mutex
+	// Config 打开元数据库使用的配置，新建和删除数据库时按这个配置定位数据目录
+	Config *Config
 }
 
 // OpenMetaConn 打开元数据库连接，主程序应该保存这个连接
-func OpenMetaConn() *MetaDBSource {
-	opts := rdb.NewDefaultOptions()
+// cfg 为nil时使用默认配置，元数据库建在数据根目录下
+func OpenMetaConn(cfg *Config) *MetaDBSource {
+	cfg = configOrDefault(cfg)
+	if err := cfg.ensureDirs(); err != nil {
+		log.Panicf("无法创建数据目录，服务即将退出: %v\n", err)
+	}
+	opts := cfg.options(METADB)
 	topts := rdb.NewDefaultTransactionDBOptions()
 	opts.SetCreateIfMissing(true)
-	meta, err := rdb.OpenTransactionDb(opts, topts, METADB)
+	meta, err := rdb.OpenTransactionDb(opts, topts, cfg.path(METADB))
 	if err != nil {
 		log.Panicf("无法打开Meta元数据库连接，此服务器已经存在严重错误，服务即将退出: %v\n", err)
 	}
@@ -39,6 +46,7 @@ func OpenMetaConn() *MetaDBSource {
 		OpenTime: nt,
 		SyncTime: nt,
 		Mu:       mutex,
+		Config:   cfg,
 	}
 }
 
@@ -58,6 +66,7 @@ func (c *MetaDBSource) GetDatabase(dbname string) (*B2Database, error) {
 		log.Printf("数据库元数据结构有错误: %v\n", err)
 		return nil, err
 	}
+	db.meta = c
 	return &db, nil
 }
 
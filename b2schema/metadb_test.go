@@ -3,13 +3,14 @@ package b2schema
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 var meta *MetaDBSource
 var db *B2Database
 
 func TestMain(t *testing.M) {
-	meta = OpenMetaConn()
+	meta = OpenMetaConn(nil)
 	ret := t.Run()
 	meta.Close()
 	os.Exit(ret)
@@ -43,10 +44,206 @@ func TestNewTable(t *testing.T) {
 	}
 }
 
-func TestDeleteTable(t *testing.T) {
-	err := db.RemoveTable("testTable", meta)
+func TestGetRow(t *testing.T) {
+	table, err := db.GetTable("testTable", meta)
 	if err != nil {
-		t.Error("deleting testDB.testTable failed")
+		t.Fatal("get testDB.testTable META failed")
+	}
+	rowKey, err := table.InsertByValues(db, "alice", int32(30))
+	if err != nil {
+		t.Fatal("inserting into testDB.testTable failed")
+	}
+	row, err := table.GetRow(db, rowKey)
+	if err != nil {
+		t.Fatalf("get row %s failed: %v", rowKey, err)
+	}
+	if row["username"] != "alice" || row["age"] != int32(30) {
+		t.Errorf("unexpected row content: %v", row)
+	}
+	var user struct {
+		Username string
+		Years    int64 `b2:"age"`
+	}
+	if err = table.GetRowInto(db, rowKey, &user); err != nil {
+		t.Fatalf("get row %s into struct failed: %v", rowKey, err)
+	}
+	if user.Username != "alice" || user.Years != 30 {
+		t.Errorf("unexpected struct content: %+v", user)
+	}
+	if _, err = table.GetRow(db, "no-such-row"); err != ErrRowNotFound {
+		t.Errorf("expected ErrRowNotFound, got %v", err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	table, err := db.GetTable("testTable", meta)
+	if err != nil {
+		t.Fatal("get testDB.testTable META failed")
+	}
+	for _, name := range []string{"bob", "carol"} {
+		if _, err = table.InsertByValues(db, name, int32(40)); err != nil {
+			t.Fatal("inserting into testDB.testTable failed")
+		}
+	}
+	rows, next, err := table.Scan(db, ScanOptions{Columns: []string{"age"}, Limit: 2})
+	if err != nil {
+		t.Fatalf("scanning testDB.testTable failed: %v", err)
+	}
+	if len(rows) != 2 || next == "" {
+		t.Fatalf("expected a full first page, got %d rows and next %q", len(rows), next)
+	}
+	if _, ok := rows[0].Values["username"]; ok {
+		t.Errorf("projected scan returned unrequested column: %v", rows[0].Values)
+	}
+	rows, next, err = table.Scan(db, ScanOptions{StartKey: next, Limit: 2})
+	if err != nil {
+		t.Fatalf("scanning testDB.testTable failed: %v", err)
+	}
+	if len(rows) != 1 || next != "" || rows[0].Values["username"] != "carol" {
+		t.Errorf("unexpected last page: %v, next %q", rows, next)
+	}
+	if _, _, err = table.Scan(db, ScanOptions{Columns: []string{"nope"}}); err == nil {
+		t.Error("scanning an unknown column should fail")
+	}
+}
+
+func TestQueryTimeRange(t *testing.T) {
+	cols := make([]B2Column, 3)
+	cols[0] = *NewColumn("ts", "timestamp")
+	cols[1] = *NewColumn("host", "string").Length(32)
+	cols[2] = *NewColumn("temperature", "float64")
+	table, err := NewTimeSeriesTable("testSeries", cols, "ts", "host", db, meta)
+	if err != nil {
+		t.Fatal("creating testDB.testSeries failed")
+	}
+	base := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, minute := range []int{3, 0, 2, 1} {
+		ts := base.Add(time.Duration(minute) * time.Minute).UnixNano()
+		host := []string{"web1", "web2"}[minute%2]
+		if _, err = table.InsertByValues(db, ts, host, float64(minute)); err != nil {
+			t.Fatal("inserting into testDB.testSeries failed")
+		}
+	}
+	rows, err := table.QueryTimeRange(db, base.Add(time.Minute), base.Add(3*time.Minute), "temperature")
+	if err != nil {
+		t.Fatalf("querying testDB.testSeries failed: %v", err)
+	}
+	if len(rows) != 2 || rows[0].Values["temperature"] != 1.0 || rows[1].Values["temperature"] != 2.0 {
+		t.Errorf("unexpected time range result: %v", rows)
+	}
+	if ts, ok := rows[0].Values["ts"].(time.Time); !ok || !ts.Equal(base.Add(time.Minute)) {
+		t.Errorf("time column should be read back as time.Time: %v", rows[0].Values["ts"])
+	}
+	rows, err = table.QuerySeriesTimeRange(db, "web1", base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("querying testDB.testSeries series web1 failed: %v", err)
+	}
+	if len(rows) != 2 || rows[0].Values["temperature"] != 0.0 || rows[1].Values["temperature"] != 2.0 {
+		t.Errorf("unexpected series time range result: %v", rows)
+	}
+	if _, err = NewTimeSeriesTable("badSeries", cols, "temperature", "", db, meta); err == nil {
+		t.Error("a float64 column should not be accepted as time column")
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	table, err := db.GetTable("testSeries", meta)
+	if err != nil {
+		t.Fatal("get testDB.testSeries META failed")
+	}
+	base := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	buckets, err := table.Aggregate(db, AggregateOptions{
+		Start:    base,
+		End:      base.Add(time.Hour),
+		Interval: 2 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("aggregating testDB.testSeries failed: %v", err)
+	}
+	if len(buckets) != 2 || !buckets[0].Start.Equal(base) {
+		t.Fatalf("unexpected buckets: %v", buckets)
+	}
+	agg := buckets[1].Values["temperature"]
+	if agg.Count != 2 || agg.Sum != 5 || agg.Min != 2 || agg.Max != 3 || agg.Mean != 2.5 ||
+		agg.First != 2 || agg.Last != 3 {
+		t.Errorf("unexpected aggregates: %+v", agg)
+	}
+	buckets, err = table.Aggregate(db, AggregateOptions{Start: base, End: base.Add(time.Hour), Columns: []string{"host"}})
+	if err != nil || len(buckets) != 1 || buckets[0].Values["host"].Count != 4 {
+		t.Errorf("a string column should only be counted: %v, %v", buckets, err)
+	}
+}
+
+func TestLookupByIndex(t *testing.T) {
+	table, err := db.GetTable("testTable", meta)
+	if err != nil {
+		t.Fatal("get testDB.testTable META failed")
+	}
+	// 写入失败的行不能出现在索引中
+	if _, err = table.InsertByValues(db, "dave", "not an int32"); err == nil {
+		t.Fatal("inserting a mismatched value should fail")
+	}
+	if uids := db.Indexes.LookupEqual(table.column("username").IndexID, "dave"); len(uids) != 0 {
+		t.Errorf("failed insert leaked into index: %v", uids)
+	}
+	if n := db.Indexes.IDIndexLen(table.TableID); n != 3 {
+		t.Errorf("expected 3 row IDs indexed, got %d", n)
+	}
+	found, err := table.LookupByIndex(db, "username", "bob")
+	if err != nil || len(found) != 1 || found[0].Values["age"] != int32(40) {
+		t.Errorf("unexpected equality lookup result: %v, %v", found, err)
+	}
+	found, err = table.LookupRangeByIndex(db, "username", "b", nil)
+	if err != nil || len(found) != 2 || found[0].Values["username"] != "bob" || found[1].Values["username"] != "carol" {
+		t.Errorf("unexpected range lookup result: %v, %v", found, err)
+	}
+	if _, err = table.LookupByIndex(db, "age", int32(40)); err == nil {
+		t.Error("looking up a column without index should fail")
+	}
+}
+
+func TestSaveAndReloadIndexes(t *testing.T) {
+	table, err := db.GetTable("testTable", meta)
+	if err != nil {
+		t.Fatal("get testDB.testTable META failed")
+	}
+	if err = db.SaveIndexes(meta); err != nil {
+		t.Fatalf("saving indexes failed: %v", err)
+	}
+	db.Close()
+	if db, err = db.OpenConnection(meta.Config); err != nil {
+		t.Fatalf("reopening testDB failed: %v", err)
+	}
+	if len(db.InvalidIndexes) != 0 {
+		t.Errorf("unexpected invalid index snapshots: %v", db.InvalidIndexes)
+	}
+	if n := db.Indexes.IDIndexLen(table.TableID); n != 3 {
+		t.Errorf("expected 3 row IDs after reload, got %d", n)
+	}
+	if found, err := table.LookupByIndex(db, "username", "carol"); err != nil || len(found) != 1 {
+		t.Errorf("lookup after reload failed: %v, %v", found, err)
+	}
+}
+
+func TestRebuildIndexes(t *testing.T) {
+	table, err := db.GetTable("testTable", meta)
+	if err != nil {
+		t.Fatal("get testDB.testTable META failed")
+	}
+	indexID := table.column("username").IndexID
+	db.Indexes.DropTableIndexes(table.TableID, indexID)
+	var last RebuildProgress
+	if err = table.RebuildIndexes(db, func(p RebuildProgress) { last = p }); err != nil {
+		t.Fatalf("rebuilding indexes failed: %v", err)
+	}
+	if !last.Done || last.Rows != 3 {
+		t.Errorf("unexpected final progress: %+v", last)
+	}
+	if n := db.Indexes.IDIndexLen(table.TableID); n != 3 {
+		t.Errorf("expected 3 row IDs after rebuild, got %d", n)
+	}
+	if found, err := table.LookupByIndex(db, "username", "carol"); err != nil || len(found) != 1 {
+		t.Errorf("lookup after rebuild failed: %v, %v", found, err)
 	}
 }
 
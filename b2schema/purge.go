@@ -0,0 +1,226 @@
+package b2schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/babydb/babydb/core"
+	"github.com/google/btree"
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+// purgeMarkerPrefix 表数据清除标记在数据库rocksdb中的键前缀，值为purgeMarker的json
+// 清除完成后标记才会被删除，中断的清除在下次打开数据库时继续
+const purgeMarkerPrefix = "\x00purge/"
+
+// purgeMarker 清除标记的内容，Drop为true表示删除表，否则为清空表
+type purgeMarker struct {
+	Table *B2Table
+	Drop  bool
+}
+
+// purgeBatchSize 清除表数据时每个事务删除的键个数
+const purgeBatchSize = 1000
+
+// purgeMarkerKey 表ID对应的清除标记键
+func purgeMarkerKey(tableID string) []byte {
+	return []byte(purgeMarkerPrefix + tableID)
+}
+
+// markPurge 同步写入表的清除标记，drop表示删除表，否则为清空表
+func (b2db *B2Database) markPurge(table *B2Table, drop bool) error {
+	value, err := json.Marshal(purgeMarker{Table: table, Drop: drop})
+	if err != nil {
+		log.Printf("将表 %s META数据转换为json时发生错误: %v\n", table.TableName, err)
+		return err
+	}
+	wopts := rdb.NewDefaultWriteOptions()
+	wopts.SetSync(true)
+	if err = b2db.RocksDbWriteConn.Put(wopts, purgeMarkerKey(table.TableID), value); err != nil {
+		log.Printf("写入表 %s 的清除标记时发生错误: %v\n", table.TableName, err)
+		return err
+	}
+	return nil
+}
+
+// purgeTable 丢弃表的内存索引，分批删除表的全部数据和索引快照，最后删除清除标记
+// drop为true时表已经删除，同时删除字典的下一个ID，否则保留，清空后新分配的字典ID不会与清空前重复
+func (b2db *B2Database) purgeTable(table *B2Table, drop bool) error {
+	if err := b2db.saveDictionaryNext(table); err != nil {
+		log.Printf("清除表 %s 的数据前保存字典时发生错误: %v\n", table.TableName, err)
+		return err
+	}
+	indexIDs := make([]string, 0, len(table.Columns))
+	for _, col := range table.Columns {
+		if len(col.IndexID) > 0 {
+			indexIDs = append(indexIDs, col.IndexID)
+		}
+	}
+	b2db.Indexes.DropTableIndexes(table.TableID, indexIDs...)
+	// 行数据、压缩块和字典使用不同的前缀
+	prefixes := [][]byte{table.keyPrefix(), table.chunkPrefix()}
+	for _, col := range table.Columns {
+		if col.Dictionary {
+			prefixes = append(prefixes, dictColumnPrefix(col.ColumnID))
+		}
+	}
+	for _, prefix := range prefixes {
+		for {
+			n, err := b2db.deletePrefixBatch(prefix, purgeBatchSize)
+			if err != nil {
+				log.Printf("清除表 %s 的数据时发生错误，下次打开数据库时将继续清除: %v\n", table.TableName, err)
+				return err
+			}
+			if n < purgeBatchSize {
+				break
+			}
+		}
+	}
+	wopts := rdb.NewDefaultWriteOptions()
+	wopts.SetSync(true)
+	topts := rdb.NewDefaultTransactionOptions()
+	txn := b2db.RocksDbWriteConn.TransactionBegin(wopts, topts, nil)
+	defer txn.Destroy()
+	keys := [][]byte{indexSnapshotKey(table.TableID)}
+	for _, indexID := range indexIDs {
+		keys = append(keys, indexSnapshotKey(indexID))
+	}
+	keys = append(keys, purgeMarkerKey(table.TableID))
+	if drop {
+		for _, col := range table.Columns {
+			if col.Dictionary {
+				keys = append(keys, dictNextKey(col.ColumnID))
+			}
+		}
+	}
+	b2db.resetDictionaries(table, drop)
+	for _, key := range keys {
+		if err := txn.Delete(key); err != nil {
+			log.Printf("删除表 %s 的索引快照和清除标记时发生错误: %v\n", table.TableName, err)
+			_ = txn.Rollback()
+			return err
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		log.Printf("提交事务时发生错误: %v\n", err)
+		_ = txn.Rollback()
+		return err
+	}
+	return nil
+}
+
+// deletePrefixBatch 在一个事务中删除最多limit个以prefix开头的键，返回删除的个数
+func (b2db *B2Database) deletePrefixBatch(prefix []byte, limit int) (int, error) {
+	var keys [][]byte
+	it, release := b2db.newIterator(rdb.NewDefaultReadOptions())
+	for it.Seek(prefix); it.ValidForPrefix(prefix) && len(keys) < limit; it.Next() {
+		key := it.Key()
+		keys = append(keys, append([]byte(nil), key.Data()...))
+		key.Free()
+	}
+	err := it.Err()
+	release()
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	wopts := rdb.NewDefaultWriteOptions()
+	topts := rdb.NewDefaultTransactionOptions()
+	txn := b2db.RocksDbWriteConn.TransactionBegin(wopts, topts, nil)
+	defer txn.Destroy()
+	for _, key := range keys {
+		if err = txn.Delete(key); err != nil {
+			_ = txn.Rollback()
+			return 0, err
+		}
+	}
+	if err = txn.Commit(); err != nil {
+		_ = txn.Rollback()
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// resumePurges 继续清除上次没有完成的表数据
+// 删除表的标记写入后、META删除前中断时表仍然存在，这时只删除标记，不清除数据
+func (b2db *B2Database) resumePurges() error {
+	var markers []purgeMarker
+	it, release := b2db.newIterator(rdb.NewDefaultReadOptions())
+	prefix := []byte(purgeMarkerPrefix)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		key := it.Key()
+		value := it.Value()
+		var marker purgeMarker
+		err := json.Unmarshal(value.Data(), &marker)
+		if err != nil || marker.Table == nil || !bytes.Equal(key.Data(), purgeMarkerKey(marker.Table.TableID)) {
+			log.Printf("数据库 %s 的清除标记 %q 无法解析: %v\n", b2db.Database, key.Data(), err)
+		} else {
+			markers = append(markers, marker)
+		}
+		key.Free()
+		value.Free()
+	}
+	err := it.Err()
+	release()
+	if err != nil {
+		log.Printf("读取数据库 %s 的清除标记时发生错误: %v\n", b2db.Database, err)
+		return err
+	}
+	for _, marker := range markers {
+		table := marker.Table
+		if marker.Drop && b2db.tableInMeta(table) {
+			log.Printf("数据库 %s 中的表 %s 没有删除完成，仍然保留，丢弃清除标记\n", b2db.Database, table.TableName)
+			wopts := rdb.NewDefaultWriteOptions()
+			wopts.SetSync(true)
+			if err = b2db.RocksDbWriteConn.Delete(wopts, purgeMarkerKey(table.TableID)); err != nil {
+				log.Printf("删除表 %s 的清除标记时发生错误: %v\n", table.TableName, err)
+				return err
+			}
+			continue
+		}
+		log.Printf("继续清除数据库 %s 中表 %s 的数据\n", b2db.Database, table.TableName)
+		if err = b2db.purgeTable(table, marker.Drop); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableInMeta 表是否仍然以相同的表ID登记在元数据库中，没有元数据库时按仍然存在处理
+func (b2db *B2Database) tableInMeta(table *B2Table) bool {
+	if b2db.meta == nil {
+		return true
+	}
+	current, err := b2db.meta.getTable(b2db.Database, table.TableName)
+	return err == nil && current.TableID == table.TableID
+}
+
+// TruncateTable 清空表中的全部数据，表结构、表ID以及字段和索引ID保持不变
+// 清空期间写入这张表的数据可能被一并删除
+func (b2db *B2Database) TruncateTable(tableName string, meta *MetaDBSource) error {
+	table, err := b2db.GetTable(tableName, meta)
+	if err != nil {
+		log.Printf("找不到要清空的表 %s: %v\n", tableName, err)
+		return err
+	}
+	if b2db.RocksDbWriteConn == nil {
+		log.Printf("数据库 %s 没有打开，无法清空表 %s\n", b2db.Database, tableName)
+		return errors.New("database is not open")
+	}
+	// 与删除表使用相同的清除标记，中断后下次打开数据库时继续清空
+	if err = b2db.markPurge(table, false); err != nil {
+		return err
+	}
+	if err = b2db.purgeTable(table, false); err != nil {
+		return err
+	}
+	normal := make(map[string]*btree.BTree)
+	for _, col := range table.Columns {
+		if col.Indexing && len(col.IndexID) > 0 {
+			normal[col.IndexID] = core.NewIndexTree()
+		}
+	}
+	b2db.Indexes.ReplaceTableIndexes(table.TableID, core.NewIndexTree(), normal)
+	return nil
+}
@@ -0,0 +1,91 @@
+package b2schema
+
+import (
+	"testing"
+
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+func TestDeleteTable(t *testing.T) {
+	b2db := openTestDatabase(t, "removeTableDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	table := newUsersTable(t, b2db, "alice", "bob")
+	if err := b2db.RemoveTable("users", meta); err != nil {
+		t.Errorf("removing users failed: %v", err)
+	}
+	if rows, _, err := table.Scan(b2db, ScanOptions{}); err != nil || len(rows) != 0 {
+		t.Errorf("rows of a removed table were not purged: %v, %v", rows, err)
+	}
+	if n := b2db.Indexes.IDIndexLen(table.TableID); n != -1 {
+		t.Errorf("ID index of a removed table was not dropped, %d rows left", n)
+	}
+	if _, ok := b2db.commitLocks[table.TableID]; ok {
+		t.Error("commit lock of a removed table was not dropped")
+	}
+}
+
+func TestResumePurge(t *testing.T) {
+	b2db := openTestDatabase(t, "resumePurgeDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	table := newUsersTable(t, b2db, "alice", "bob")
+	// 模拟写入清除标记并删除META后进程崩溃
+	if err := b2db.markPurge(table, true); err != nil {
+		t.Fatalf("writing purge marker failed: %v", err)
+	}
+	if err := b2db.removeTableMeta("users", meta); err != nil {
+		t.Fatalf("removing users META failed: %v", err)
+	}
+	b2db.closeConns()
+	var err error
+	if b2db, err = b2db.OpenConnection(meta.Config); err != nil {
+		t.Fatalf("reopening resumePurgeDB failed: %v", err)
+	}
+	if rows, _, err := table.Scan(b2db, ScanOptions{}); err != nil || len(rows) != 0 {
+		t.Errorf("interrupted purge was not resumed: %v, %v", rows, err)
+	}
+	slice, err := b2db.RocksDbWriteConn.Get(rdb.NewDefaultReadOptions(), purgeMarkerKey(table.TableID))
+	if err != nil || slice.Exists() {
+		t.Errorf("purge marker should be removed after purging: %v", err)
+	}
+	slice.Free()
+}
+
+func TestStalePurgeMarker(t *testing.T) {
+	b2db := openTestDatabase(t, "stalePurgeDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	table := newUsersTable(t, b2db, "alice", "bob")
+	// 模拟写入清除标记后、删除META前进程崩溃: 表仍然存在，数据不能被清除
+	if err := b2db.markPurge(table, true); err != nil {
+		t.Fatalf("writing purge marker failed: %v", err)
+	}
+	b2db.closeConns()
+	var err error
+	if b2db, err = b2db.OpenConnection(meta.Config); err != nil {
+		t.Fatalf("reopening stalePurgeDB failed: %v", err)
+	}
+	if rows, _, err := table.Scan(b2db, ScanOptions{}); err != nil || len(rows) != 2 {
+		t.Errorf("a table still in META was purged: %v, %v", rows, err)
+	}
+	slice, err := b2db.RocksDbWriteConn.Get(rdb.NewDefaultReadOptions(), purgeMarkerKey(table.TableID))
+	if err != nil || slice.Exists() {
+		t.Errorf("stale purge marker should be removed: %v", err)
+	}
+	slice.Free()
+}
+
+func TestRemoveTableWhenClosed(t *testing.T) {
+	b2db := openTestDatabase(t, "removeClosedDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	newUsersTable(t, b2db, "alice")
+	b2db.Close()
+	if err := b2db.RemoveTable("users", meta); err != nil {
+		t.Errorf("removing users from a closed database failed: %v", err)
+	}
+	if _, err := meta.getTable(b2db.Database, "users"); err == nil {
+		t.Error("META of users should be removed")
+	}
+	var err error
+	if b2db, err = b2db.OpenConnection(meta.Config); err != nil {
+		t.Fatalf("reopening removeClosedDB failed: %v", err)
+	}
+}
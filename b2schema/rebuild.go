@@ -0,0 +1,190 @@
+package b2schema
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/babydb/babydb/core"
+	"github.com/google/btree"
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+// rebuildProgressStep 每扫描多少行报告一次重建进度
+const rebuildProgressStep = 10000
+
+// RebuildProgress 索引重建进度
+type RebuildProgress struct {
+	// TableName 正在重建索引的表
+	TableName string
+	// Rows 已经扫描的行数
+	Rows int64
+	// Done 重建是否已经完成
+	Done bool
+}
+
+// rebuildJournal 索引重建期间提交的索引变更，重建完成时回放到新的索引树上
+type rebuildJournal struct {
+	mu  sync.Mutex
+	ops []*indexOps
+}
+
+// activeRebuild 返回表正在进行的重建的变更日志，没有重建时返回nil
+func (b2db *B2Database) activeRebuild(tableID string) *rebuildJournal {
+	b2db.indexMu.Lock()
+	defer b2db.indexMu.Unlock()
+	return b2db.rebuilds[tableID]
+}
+
+// RebuildIndexes 扫描表中的数据重建ID索引和全部字段索引
+// 重建基于rocksdb快照在新的索引树上进行，期间写入不受阻塞，提交的索引变更会被记录下来，
+// 重建完成时回放到新索引树上并整体替换旧索引。progress为nil时不报告进度
+func (t *B2Table) RebuildIndexes(db *B2Database, progress func(RebuildProgress)) error {
+	db.indexMu.Lock()
+	if _, ok := db.rebuilds[t.TableID]; ok {
+		db.indexMu.Unlock()
+		log.Printf("表 %s 的索引正在重建中\n", t.TableName)
+		return errors.New("index rebuild already in progress")
+	}
+	if db.rebuilds == nil {
+		db.rebuilds = make(map[string]*rebuildJournal)
+	}
+	journal := &rebuildJournal{}
+	db.rebuilds[t.TableID] = journal
+	db.indexMu.Unlock()
+	defer func() {
+		db.indexMu.Lock()
+		if db.rebuilds[t.TableID] == journal {
+			delete(db.rebuilds, t.TableID)
+		}
+		db.indexMu.Unlock()
+	}()
+
+	// 先登记变更日志再取快照，快照之后提交的变更一定会被记录
+	snap := db.RocksDbWriteConn.NewSnapshot()
+	defer db.RocksDbWriteConn.ReleaseSnapshot(snap)
+	ropts := rdb.NewDefaultReadOptions()
+	ropts.SetSnapshot(snap)
+	ropts.SetFillCache(false)
+
+	idTree := core.NewIndexTree()
+	normal := make(map[string]*btree.BTree)
+	projected := make(map[string]bool)
+	for _, col := range t.Columns {
+		if col.Indexing && len(col.IndexID) > 0 {
+			normal[col.IndexID] = core.NewIndexTree()
+			projected[col.ColumnName] = true
+		}
+	}
+	byID, _, _ := t.projection(nil)
+	it, release := db.newIterator(ropts)
+	defer release()
+	prefix := t.keyPrefix()
+	it.Seek(prefix)
+	var rows int64
+	_, err := t.collectRows(it, byID, projected, nil, func(key []byte) bool {
+		return bytes.HasPrefix(key, prefix)
+	}, func(row Row) bool {
+		idTree.ReplaceOrInsert(core.IDIndex(row.Key))
+		t.eachIndex(row.Values, func(indexID string, value interface{}) {
+			core.NormalIndex{Value: value, UID: []string{row.Key}}.InsertInto(normal[indexID])
+		})
+		rows++
+		if progress != nil && rows%rebuildProgressStep == 0 {
+			progress(RebuildProgress{TableName: t.TableName, Rows: rows})
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf("重建表 %s 的索引时发生错误: %v\n", t.TableName, err)
+		return err
+	}
+
+	// 回放重建期间提交的变更并切换到新索引，持有日志锁期间新的变更会等待
+	journal.mu.Lock()
+	for _, ops := range journal.ops {
+		ops.replay(idTree, normal)
+	}
+	db.Indexes.ReplaceTableIndexes(t.TableID, idTree, normal)
+	journal.mu.Unlock()
+	if progress != nil {
+		progress(RebuildProgress{TableName: t.TableName, Rows: rows, Done: true})
+	}
+	return nil
+}
+
+// replay 将一组索引变更应用到重建中的索引树上
+func (ops *indexOps) replay(idTree *btree.BTree, normal map[string]*btree.BTree) {
+	for _, row := range ops.deletes {
+		idTree.Delete(core.IDIndex(row.rowKey))
+		ops.table.eachIndex(row.values, func(indexID string, value interface{}) {
+			if tree, ok := normal[indexID]; ok {
+				core.NormalIndex{Value: value, UID: []string{row.rowKey}}.DeleteFrom(tree)
+			}
+		})
+	}
+	for _, row := range ops.inserts {
+		idTree.ReplaceOrInsert(core.IDIndex(row.rowKey))
+		ops.table.eachIndex(row.values, func(indexID string, value interface{}) {
+			if tree, ok := normal[indexID]; ok {
+				core.NormalIndex{Value: value, UID: []string{row.rowKey}}.InsertInto(tree)
+			}
+		})
+	}
+	for _, u := range ops.updates {
+		ops.table.eachIndex(u.before, func(indexID string, value interface{}) {
+			if tree, ok := normal[indexID]; ok {
+				core.NormalIndex{Value: value, UID: []string{u.rowKey}}.DeleteFrom(tree)
+			}
+		})
+		ops.table.eachIndex(u.after, func(indexID string, value interface{}) {
+			if tree, ok := normal[indexID]; ok {
+				core.NormalIndex{Value: value, UID: []string{u.rowKey}}.InsertInto(tree)
+			}
+		})
+	}
+}
+
+// RebuildInvalidIndexes 重建快照校验失败的索引所在的表，OpenConnection还原快照后会自动调用
+func (b2db *B2Database) RebuildInvalidIndexes(meta *MetaDBSource, progress func(RebuildProgress)) error {
+	if len(b2db.InvalidIndexes) == 0 {
+		return nil
+	}
+	if meta == nil {
+		return errNoMeta
+	}
+	invalid := make(map[string]bool, len(b2db.InvalidIndexes))
+	for _, id := range b2db.InvalidIndexes {
+		invalid[id] = true
+	}
+	for _, name := range b2db.TableList {
+		table, err := b2db.GetTable(name, meta)
+		if err == ErrTableFormat {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ids := []string{table.TableID}
+		for _, col := range table.Columns {
+			ids = append(ids, col.IndexID)
+		}
+		affected := false
+		for _, id := range ids {
+			if invalid[id] {
+				affected = true
+				delete(invalid, id)
+			}
+		}
+		if !affected {
+			continue
+		}
+		if err = table.RebuildIndexes(b2db, progress); err != nil {
+			return err
+		}
+	}
+	// 剩下的是已经不属于任何表的快照，忽略即可
+	b2db.InvalidIndexes = nil
+	return nil
+}
@@ -0,0 +1,133 @@
+package b2schema
+
+import (
+	"errors"
+	"log"
+	"reflect"
+	"strings"
+
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+// ErrRowNotFound 表中不存在指定的行
+var ErrRowNotFound = errors.New("row not found")
+
+// rowColumnKey 生成一行中某个字段在rocksdb中的存储键: rowKey/ColumnID
+func rowColumnKey(rowKey, columnID string) []byte {
+	return []byte(rowKey + "/" + columnID)
+}
+
+// GetRow 按行键读取一整行数据，返回以字段名称为键的map
+// 行中任何一个字段都不存在时返回ErrRowNotFound，值为NULL的字段不会出现在结果中
+func (t *B2Table) GetRow(db *B2Database, rowKey string) (map[string]interface{}, error) {
+	// 只读连接只能看到打开时刻的数据，所以读取走事务连接
+	ropts := rdb.NewDefaultReadOptions()
+	row := make(map[string]interface{}, len(t.Columns))
+	for _, col := range t.Columns {
+		slice, err := db.RocksDbWriteConn.Get(ropts, rowColumnKey(rowKey, col.ColumnID))
+		if err != nil {
+			log.Printf("读取表 %s 行 %s 字段 %s 时发生错误: %v\n", t.TableName, rowKey, col.ColumnName, err)
+			return nil, err
+		}
+		if !slice.Exists() {
+			slice.Free()
+			continue
+		}
+		m, err := col.ParseMap(slice.Data())
+		slice.Free()
+		if err != nil {
+			log.Printf("解析表 %s 行 %s 字段 %s 时发生错误: %v\n", t.TableName, rowKey, col.ColumnName, err)
+			return nil, err
+		}
+		row[col.ColumnName] = m[col.ColumnName]
+	}
+	if len(row) == 0 {
+		return nil, ErrRowNotFound
+	}
+	chunks, release := t.openChunkReader(db)
+	defer release()
+	if err := chunks.fill(rowKey, row, nil); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// GetRowInto 按行键读取一整行数据，并填充到out指向的结构体中
+// 结构体字段通过标签 `b2:"字段名"` 与表字段对应，没有标签时按字段名称（不区分大小写）对应
+// 需要区分NULL和零值时可以使用指针类型的结构体字段，NULL对应nil
+func (t *B2Table) GetRowInto(db *B2Database, rowKey string, out interface{}) error {
+	row, err := t.GetRow(db, rowKey)
+	if err != nil {
+		return err
+	}
+	return fillStruct(row, out)
+}
+
+// fillStruct 将一行数据的map填充到结构体指针中
+func fillStruct(row map[string]interface{}, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("out must be a non-nil pointer to struct")
+	}
+	sv := rv.Elem()
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		if field.PkgPath != "" {
+			// 未导出字段
+			continue
+		}
+		name := field.Tag.Get("b2")
+		if name == "-" {
+			continue
+		}
+		value, ok := row[name]
+		if name == "" {
+			value, ok = lookupFold(row, field.Name)
+		}
+		if !ok || value == nil {
+			continue
+		}
+		fv := sv.Field(i)
+		if fv.Kind() == reflect.Ptr {
+			// 指针字段: 字段值为NULL时保持nil，有值时指向一个新值
+			p := reflect.New(fv.Type().Elem())
+			if err := assignValue(p.Elem(), value, field.Name); err != nil {
+				return err
+			}
+			fv.Set(p)
+			continue
+		}
+		if err := assignValue(fv, value, field.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assignValue 将一个字段值赋给结构体字段，数值类型之间可以转换
+func assignValue(fv reflect.Value, value interface{}, fieldName string) error {
+	vv := reflect.ValueOf(value)
+	switch {
+	case vv.Type().AssignableTo(fv.Type()):
+		fv.Set(vv)
+	case vv.Type().ConvertibleTo(fv.Type()) && vv.Kind() != reflect.String && fv.Kind() != reflect.String:
+		fv.Set(vv.Convert(fv.Type()))
+	default:
+		log.Printf("值 %v 无法赋给结构体字段 %s (%s)\n", value, fieldName, fv.Type())
+		return errors.New("value and struct field type mismatched")
+	}
+	return nil
+}
+
+func lookupFold(row map[string]interface{}, name string) (interface{}, bool) {
+	if v, ok := row[name]; ok {
+		return v, true
+	}
+	for k, v := range row {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,103 @@
+package b2schema
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// 行键布局: TableID + "/" + uvarint(len(series)) + series + 大端时间戳(8字节) + xid(12字节)
+// 同一张表、同一个序列的行在rocksdb中按时间顺序相邻存放，时间范围查询可以直接Seek
+
+const (
+	// rowKeyTimeLen 行键中时间戳部分的长度
+	rowKeyTimeLen = 8
+	// rowKeySuffixLen 行键中时间戳与xid两部分的总长度
+	rowKeySuffixLen = rowKeyTimeLen + len(xid.ID{})
+)
+
+// keyPrefix 表中所有数据键的公共前缀
+func (t *B2Table) keyPrefix() []byte {
+	return []byte(t.TableID + "/")
+}
+
+// seriesPrefix 表中某个序列所有数据键的公共前缀，序列值带长度前缀，不同序列之间不会互为前缀
+func (t *B2Table) seriesPrefix(series []byte) []byte {
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	hl := binary.PutUvarint(lenBuf, uint64(len(series)))
+	prefix := t.keyPrefix()
+	prefix = append(prefix, lenBuf[:hl]...)
+	return append(prefix, series...)
+}
+
+// newRowKey 生成一个新的行键
+func (t *B2Table) newRowKey(series []byte, ts int64) string {
+	guid := xid.New()
+	key := append(t.seriesPrefix(series), encodeKeyTime(ts)...)
+	key = append(key, guid[:]...)
+	return string(key)
+}
+
+// rowKeyFor 根据一行已经编码好的字段值（字段名称->字节数组）生成行键
+// 序列取SeriesColumn的值，时间取TimeColumn的值，没有时间轴字段的表使用写入时间
+func (t *B2Table) rowKeyFor(encoded map[string][]byte) string {
+	var series []byte
+	if len(t.SeriesColumn) > 0 {
+		series = encoded[t.SeriesColumn]
+	}
+	ts := time.Now().UnixNano()
+	if v, ok := encoded[t.TimeColumn]; ok && len(v) == 8 {
+		ts = BytesToTime(v).UnixNano()
+	}
+	return t.newRowKey(series, ts)
+}
+
+// encodeKeyTime 将时间戳编码为可按字节序比较的8字节大端值（翻转符号位，负数排在前面）
+func encodeKeyTime(ts int64) []byte {
+	bs := make([]byte, rowKeyTimeLen)
+	binary.BigEndian.PutUint64(bs, uint64(ts)^(1<<63))
+	return bs
+}
+
+// decodeKeyTime encodeKeyTime的逆操作
+func decodeKeyTime(bs []byte) int64 {
+	return int64(binary.BigEndian.Uint64(bs) ^ (1 << 63))
+}
+
+// rowKeyTime 从行键中取出时间戳
+func rowKeyTime(rowKey string) (int64, bool) {
+	if len(rowKey) < rowKeySuffixLen {
+		return 0, false
+	}
+	pos := len(rowKey) - rowKeySuffixLen
+	return decodeKeyTime([]byte(rowKey[pos : pos+rowKeyTimeLen])), true
+}
+
+// rowKeyID 从行键中取出xid部分
+func rowKeyID(rowKey string) (string, bool) {
+	if len(rowKey) < rowKeySuffixLen {
+		return "", false
+	}
+	return rowKey[len(rowKey)-len(xid.ID{}):], true
+}
+
+// rowKeySeriesPrefix 从行键中取出序列前缀
+func rowKeySeriesPrefix(rowKey string) ([]byte, bool) {
+	if len(rowKey) < rowKeySuffixLen {
+		return nil, false
+	}
+	return []byte(rowKey[:len(rowKey)-rowKeySuffixLen]), true
+}
+
+// prefixSuccessor 返回大于所有以prefix开头的键的最小键，prefix全为0xFF时返回nil
+func prefixSuccessor(prefix []byte) []byte {
+	succ := append([]byte(nil), prefix...)
+	for i := len(succ) - 1; i >= 0; i-- {
+		if succ[i] < 0xFF {
+			succ[i]++
+			return succ[:i+1]
+		}
+	}
+	return nil
+}
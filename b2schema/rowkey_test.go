@@ -0,0 +1,32 @@
+package b2schema
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestRowKeyOrdering(t *testing.T) {
+	table := B2Table{TableID: "b6mh5tqs00c7rq5rtcog"}
+	times := []int64{math.MinInt64, -1, 0, 1, 1546300800000000000, math.MaxInt64}
+	var prev string
+	for i, ts := range times {
+		key := table.newRowKey([]byte("web1"), ts)
+		if got, ok := rowKeyTime(key); !ok || got != ts {
+			t.Errorf("row key time %d decoded as %d", ts, got)
+		}
+		if i > 0 && key <= prev {
+			t.Errorf("row key for %d does not sort after %d", ts, times[i-1])
+		}
+		prev = key
+	}
+	// 不同序列之间不能互为前缀
+	short := table.seriesPrefix([]byte("web"))
+	long := table.seriesPrefix([]byte("web1"))
+	if bytes.HasPrefix(long, short) {
+		t.Error("series prefix of web is a prefix of series web1")
+	}
+	if succ := prefixSuccessor([]byte{0x01, 0xFF}); !bytes.Equal(succ, []byte{0x02}) {
+		t.Errorf("unexpected prefix successor: %v", succ)
+	}
+}
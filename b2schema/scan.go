@@ -0,0 +1,172 @@
+package b2schema
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+// Row 表中的一行数据
+type Row struct {
+	// Key 行键
+	Key string
+	// Values 以字段名称为键的字段值
+	Values map[string]interface{}
+}
+
+// ScanOptions 表扫描参数
+type ScanOptions struct {
+	// Columns 投影字段名称列表，为空时返回全部字段
+	Columns []string
+	// StartKey 起始行键（包含），为空时从头开始扫描
+	StartKey string
+	// Limit 最多返回的行数，0表示不限制
+	Limit int
+}
+
+// Scan 按行键顺序扫描表，返回本页数据以及下一页的起始行键
+// 下一页起始行键为空表示已经扫描到表的末尾
+func (t *B2Table) Scan(db *B2Database, opts ScanOptions) ([]Row, string, error) {
+	var rows []Row
+	var next string
+	err := t.ScanFunc(db, opts, func(row Row) bool {
+		if opts.Limit > 0 && len(rows) >= opts.Limit {
+			next = row.Key
+			return false
+		}
+		rows = append(rows, row)
+		return true
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return rows, next, nil
+}
+
+// ScanFunc 按行键顺序扫描表，每组装出一行就调用一次fn，fn返回false时停止扫描
+// opts.Limit在这里不生效，由调用方在fn中自行控制
+func (t *B2Table) ScanFunc(db *B2Database, opts ScanOptions, fn func(Row) bool) error {
+	byID, projected, err := t.projection(opts.Columns)
+	if err != nil {
+		return err
+	}
+	it, release := db.newIterator(rdb.NewDefaultReadOptions())
+	defer release()
+	prefix := t.keyPrefix()
+	if len(opts.StartKey) > 0 && opts.StartKey > string(prefix) {
+		it.Seek([]byte(opts.StartKey))
+	} else {
+		it.Seek(prefix)
+	}
+	chunks, releaseChunks := t.openChunkReader(db)
+	defer releaseChunks()
+	_, err = t.collectRows(it, byID, projected, chunks, func(key []byte) bool {
+		return bytes.HasPrefix(key, prefix)
+	}, fn)
+	return err
+}
+
+// collectRows 从迭代器当前位置开始把连续的 rowKey/ColumnID 键值对组装成行并交给fn
+// 遇到inRange返回false的键时停止，迭代器停在该键上；返回值表示fn是否希望继续
+// chunks不为nil时，行中没有单独存储的压缩字段从压缩块中补上
+func (t *B2Table) collectRows(it *rdb.Iterator, byID map[string]*B2Column, projected map[string]bool,
+	chunks *chunkReader, inRange func(key []byte) bool, fn func(Row) bool) (bool, error) {
+	var cur *Row
+	emit := func() (bool, error) {
+		if err := chunks.fill(cur.Key, cur.Values, projected); err != nil {
+			return false, err
+		}
+		return fn(*cur), nil
+	}
+	for ; it.Valid(); it.Next() {
+		key := it.Key()
+		k := string(key.Data())
+		key.Free()
+		if !inRange([]byte(k)) {
+			break
+		}
+		rowKey, colID, ok := splitRowColumnKey(k)
+		if !ok {
+			continue
+		}
+		col, ok := byID[colID]
+		if !ok {
+			// 不属于本表的数据
+			continue
+		}
+		if cur == nil || cur.Key != rowKey {
+			if cur != nil {
+				if more, err := emit(); err != nil || !more {
+					return false, err
+				}
+			}
+			cur = &Row{Key: rowKey, Values: make(map[string]interface{}, len(projected))}
+		}
+		if !projected[col.ColumnName] {
+			continue
+		}
+		value := it.Value()
+		m, err := col.ParseMap(value.Data())
+		value.Free()
+		if err != nil {
+			log.Printf("解析表 %s 行 %s 字段 %s 时发生错误: %v\n", t.TableName, rowKey, col.ColumnName, err)
+			return false, err
+		}
+		cur.Values[col.ColumnName] = m[col.ColumnName]
+	}
+	if err := it.Err(); err != nil {
+		log.Printf("扫描表 %s 时发生错误: %v\n", t.TableName, err)
+		return false, err
+	}
+	if cur != nil {
+		return emit()
+	}
+	return true, nil
+}
+
+// projection 根据投影字段名称列表生成 ColumnID->字段 的映射以及需要返回的字段集合
+func (t *B2Table) projection(names []string) (map[string]*B2Column, map[string]bool, error) {
+	byID := make(map[string]*B2Column, len(t.Columns))
+	byName := make(map[string]bool, len(t.Columns))
+	for i := range t.Columns {
+		byID[t.Columns[i].ColumnID] = &t.Columns[i]
+		byName[t.Columns[i].ColumnName] = true
+	}
+	if len(names) == 0 {
+		return byID, byName, nil
+	}
+	projected := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !byName[name] {
+			log.Printf("表 %s 中不存在字段 %s\n", t.TableName, name)
+			return nil, nil, errors.New("no such column")
+		}
+		projected[name] = true
+	}
+	return byID, projected, nil
+}
+
+// splitRowColumnKey 将存储键 rowKey/ColumnID 拆分为行键和字段ID
+func splitRowColumnKey(key string) (string, string, bool) {
+	pos := strings.LastIndex(key, "/")
+	if pos <= 0 || pos == len(key)-1 {
+		return "", "", false
+	}
+	return key[:pos], key[pos+1:], true
+}
+
+// newIterator 在事务连接上创建一个迭代器，使用完毕后必须调用返回的release函数
+func (db *B2Database) newIterator(ropts *rdb.ReadOptions) (*rdb.Iterator, func()) {
+	wopts := rdb.NewDefaultWriteOptions()
+	topts := rdb.NewDefaultTransactionOptions()
+	txn := db.RocksDbWriteConn.TransactionBegin(wopts, topts, nil)
+	it := txn.NewIterator(ropts)
+	return it, func() {
+		it.Close()
+		_ = txn.Rollback()
+		txn.Destroy()
+	}
+}
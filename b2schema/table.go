@@ -19,16 +19,54 @@ type B2Table struct {
 	CreateTime time.Time `json:"CreateTime"`
 	// TableID 全局唯一表ID
 	TableID string `json:"TableID"`
+	// TimeColumn 作为时间轴的timestamp字段名称，为空表示普通表
+	TimeColumn string `json:"TimeColumn,omitempty"`
+	// SeriesColumn 区分不同序列的字段名称，同一序列的行在存储中按时间相邻
+	SeriesColumn string `json:"SeriesColumn,omitempty"`
+	// FormatVersion 建表时的存储格式版本，见tableFormatVersion
+	FormatVersion int `json:"FormatVersion,omitempty"`
+}
+
+// tableFormatVersion 当前的表存储格式版本
+// 版本1: timestamp字段值由小端int64改为翻转符号位的大端Unix纳秒数
+// 没有版本号的旧表中timestamp字段值的编码不同，这样的表不能再读写，只能删除
+const tableFormatVersion = 1
+
+// ErrTableFormat 表的存储格式版本过旧
+var ErrTableFormat = errors.New("table storage format is too old")
+
+// checkFormat 检查表的存储格式是否可以读写，只有包含timestamp字段的旧表受格式变化影响
+func (t *B2Table) checkFormat() error {
+	if t.FormatVersion >= tableFormatVersion {
+		return nil
+	}
+	for _, col := range t.Columns {
+		if col.DataType == B2Timestamp.TypeName {
+			log.Printf("表 %s 的存储格式版本 %d 过旧，timestamp字段 %s 的编码已经改变\n", t.TableName, t.FormatVersion, col.ColumnName)
+			return ErrTableFormat
+		}
+	}
+	return nil
 }
 
 // NewTable 新建一张数据库表
 func NewTable(name string, cols []B2Column,
+	b2db *B2Database, meta *MetaDBSource) (*B2Table, error) {
+	return NewTimeSeriesTable(name, cols, "", "", b2db, meta)
+}
+
+// NewTimeSeriesTable 新建一张以timeColumn字段作为时间轴的时间序列表
+// seriesColumn 为区分序列的字段，可以为空，表示整张表只有一个序列
+func NewTimeSeriesTable(name string, cols []B2Column, timeColumn, seriesColumn string,
 	b2db *B2Database, meta *MetaDBSource) (*B2Table, error) {
 	var t B2Table
 	t.TableName = name
 	t.TableID = xid.New().String()
 	t.CreateTime = time.Now()
 	t.Columns = cols
+	t.TimeColumn = timeColumn
+	t.SeriesColumn = seriesColumn
+	t.FormatVersion = tableFormatVersion
 	if err := b2db.AddTable(&t, meta); err != nil {
 		return nil, err
 	}
@@ -43,36 +81,121 @@ func (t *B2Table) validate() bool {
 		if len(col.ColumnID) == 0 || len(col.ColumnName) == 0 || len(col.DataType) == 0 {
 			return false
 		}
+		if _, err := col.precisionUnit(); col.DataType == B2Timestamp.TypeName && err != nil {
+			return false
+		}
+		// 压缩块按序列和时间戳定位，只支持时间序列表中没有索引的数值字段
+		if col.Compressed && (!col.IsNumeric() || col.Indexing || len(t.TimeColumn) == 0) {
+			return false
+		}
+		if col.Dictionary && col.DataType != B2String.TypeName {
+			return false
+		}
+	}
+	if len(t.TimeColumn) > 0 {
+		col := t.column(t.TimeColumn)
+		if col == nil || col.DataType != B2Timestamp.TypeName {
+			return false
+		}
+	}
+	if len(t.SeriesColumn) > 0 && t.column(t.SeriesColumn) == nil {
+		return false
 	}
 	return true
 }
 
+// column 按名称查找字段，找不到时返回nil
+func (t *B2Table) column(name string) *B2Column {
+	for i := range t.Columns {
+		if t.Columns[i].ColumnName == name {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
+
 // InsertByValues 向表中插入一行数据
 func (t *B2Table) InsertByValues(db *B2Database, values ...interface{}) (string, error) {
-	rowKey := xid.New().String()
 	wopts := rdb.NewDefaultWriteOptions()
 	topts := rdb.NewDefaultTransactionOptions()
+	encoded, err := t.encodeValues(values)
+	if err != nil {
+		return "", err
+	}
+	rowKey := t.rowKeyFor(encoded)
+	ops := newIndexOps(t)
+	ops.insertRow(rowKey, encoded)
+	txn := db.RocksDbWriteConn.TransactionBegin(wopts, topts, nil)
+	if err = t.writeRow(txn, rowKey, encoded); err != nil {
+		_ = txn.Rollback()
+		return "", err
+	}
+	err = ops.commit(txn, db)
+	if err != nil {
+		log.Printf("提交事务时发生错误: %v\n", err)
+		_ = txn.Rollback()
+		return "", err
+	}
+	return rowKey, nil
+}
+
+// encodeValues 按字段顺序校验并编码一行数据，返回字段名称->字节数组，值为nil的字段按fillMissing的规则处理
+func (t *B2Table) encodeValues(values []interface{}) (map[string][]byte, error) {
 	if len(t.Columns) != len(values) {
 		log.Printf("表字段个数与值个数不相符，字段数: %d，值个数: %d\n", len(t.Columns), len(values))
-		return "", errors.New("fields and values mismatch")
+		return nil, errors.New("fields and values mismatch")
 	}
-	txn := db.RocksDbWriteConn.TransactionBegin(wopts, topts, nil)
+	encoded := make(map[string][]byte, len(t.Columns))
 	for i, col := range t.Columns {
+		if values[i] == nil {
+			continue
+		}
 		colValue, err := col.FormatBytes(values[i])
 		if err != nil {
 			log.Printf("字段定义与值类型转换出错: %v\n", err)
-			txn.Rollback()
-			return "", err
+			return nil, err
 		}
-		k := rowKey + "/" + col.ColumnID
-		err = writeKV([]byte(k), colValue, txn)
-		if err != nil {
+		encoded[col.ColumnName] = colValue
+	}
+	if err := t.fillMissing(encoded); err != nil {
+		return nil, err
+	}
+	return encoded, nil
+}
+
+// writeRow 在事务中写入一行已经编码的字段
+func (t *B2Table) writeRow(txn *rdb.Transaction, rowKey string, encoded map[string][]byte) error {
+	for _, col := range t.Columns {
+		colValue, ok := encoded[col.ColumnName]
+		if !ok {
+			continue
+		}
+		if err := writeKV(rowColumnKey(rowKey, col.ColumnID), colValue, txn); err != nil {
 			log.Printf("写入字段数据时发生错误: %v\n", err)
-			_ = txn.Rollback()
-			return "", err
+			return err
 		}
 	}
-	err := txn.Commit()
+	return nil
+}
+
+// InsertByMap 使用KV对向表中插入一行数据，values为字段名称->值
+// 数据与InsertByValues使用相同的 rowKey/ColumnID 存储布局，map中没有或值为nil的字段按fillMissing的规则处理
+func (t *B2Table) InsertByMap(db *B2Database, values map[string]interface{}) (string, error) {
+	wopts := rdb.NewDefaultWriteOptions()
+	topts := rdb.NewDefaultTransactionOptions()
+	encoded, err := t.encodeMap(values)
+	if err != nil {
+		return "", err
+	}
+	rowKey := t.rowKeyFor(encoded)
+	ops := newIndexOps(t)
+	ops.insertRow(rowKey, encoded)
+	txn := db.RocksDbWriteConn.TransactionBegin(wopts, topts, nil)
+	if err = t.writeRow(txn, rowKey, encoded); err != nil {
+		_ = txn.Rollback()
+		return "", err
+	}
+	err = ops.commit(txn, db)
 	if err != nil {
 		log.Printf("提交事务时发生错误: %v\n", err)
 		_ = txn.Rollback()
@@ -81,44 +204,64 @@ func (t *B2Table) InsertByValues(db *B2Database, values ...interface{}) (string,
 	return rowKey, nil
 }
 
-// InsertByMap 使用KV对向表中插入一行数据
-func (t *B2Table) InsertByMap(db *B2Database, values map[string]interface{}) (string, error) {
-	rowKey := xid.New().String()
-	wopts := rdb.NewDefaultWriteOptions()
-	topts := rdb.NewDefaultTransactionOptions()
-	if len(values) > len(t.Columns) {
-		log.Printf("数据个数与字段个数不符，values: %d，columns: %d\n", len(values), len(t.Columns))
-		return "", errors.New("values more than fields")
+// encodeMap 校验并编码以字段名称为键的一行数据，不会修改values
+func (t *B2Table) encodeMap(values map[string]interface{}) (map[string][]byte, error) {
+	encoded := make(map[string][]byte, len(t.Columns))
+	for name, value := range values {
+		col := t.column(name)
+		if col == nil {
+			log.Printf("数据值中的 %s 与表 %s 的字段定义不符\n", name, t.TableName)
+			return nil, errors.New("values map and columns definition mismatched")
+		}
+		if value == nil {
+			continue
+		}
+		colValue, err := col.FormatBytes(value)
+		if err != nil {
+			log.Printf("字段定义与值类型转换出错: %v\n", err)
+			return nil, err
+		}
+		encoded[name] = colValue
 	}
-	txn := db.RocksDbWriteConn.TransactionBegin(wopts, topts, nil)
+	if err := t.fillMissing(encoded); err != nil {
+		return nil, err
+	}
+	return encoded, nil
+}
+
+// fillMissing 处理一行中没有提供值的字段: 有默认值时使用默认值；
+// 时间字段使用当前时间，使行键中的时间与字段值一致；允许NULL的字段不写入存储；其余字段返回ErrNotNull
+func (t *B2Table) fillMissing(encoded map[string][]byte) error {
 	for _, col := range t.Columns {
-		if _, ok := values[col.ColumnName]; ok {
-			colValue, err := col.FormatBytes(values[col.ColumnName])
+		if _, ok := encoded[col.ColumnName]; ok {
+			continue
+		}
+		switch {
+		case len(col.Default) > 0 && col.Dictionary:
+			bs, err := col.dictEncode(string(col.Default))
 			if err != nil {
-				log.Printf("字段定义与值类型转换出错: %v\n", err)
-				_ = txn.Rollback()
-				return "", err
+				return err
 			}
-			if err = writeKV([]byte(col.ColumnName), colValue, txn); err != nil {
-				log.Printf("写入字段数据时发生错误: %v\n", err)
-				_ = txn.Rollback()
-				return "", err
+			encoded[col.ColumnName] = bs
+		case len(col.Default) > 0:
+			encoded[col.ColumnName] = append([]byte(nil), col.Default...)
+		case col.ColumnName == t.TimeColumn:
+			now, err := col.FormatBytes(time.Now())
+			if err != nil {
+				return err
 			}
-			delete(values, col.ColumnName)
+			encoded[col.ColumnName] = now
+		case !col.NotNull:
+		default:
+			log.Printf("表 %s 的字段 %s 不允许NULL并且没有默认值\n", t.TableName, col.ColumnName)
+			return ErrNotNull
 		}
 	}
-	if len(values) > 0 {
-		log.Println("数据值中存在与字段定义名称不符的部分")
-		txn.Rollback()
-		return "", errors.New("values map and columns definition mismatched")
-	}
-	err := txn.Commit()
-	if err != nil {
-		log.Printf("提交事务时发生错误: %v\n", err)
-		_ = txn.Rollback()
-		return "", err
+	if len(encoded) == 0 {
+		log.Printf("向表 %s 插入的数据没有任何字段值\n", t.TableName)
+		return errors.New("empty row")
 	}
-	return rowKey, nil
+	return nil
 }
 
 func writeKV(key, value []byte, txn *rdb.Transaction) error {
@@ -0,0 +1,131 @@
+package b2schema
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+func TestInsertByMap(t *testing.T) {
+	b2db := openTestDatabase(t, "insertMapDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	table := newUsersTable(t, b2db)
+	values := map[string]interface{}{"username": "ivan", "age": int32(60)}
+	first, err := table.InsertByMap(b2db, values)
+	if err != nil || len(values) != 2 {
+		t.Fatalf("inserting by map failed or mutated its input: %v, %v", err, values)
+	}
+	second, err := table.InsertByMap(b2db, map[string]interface{}{"username": "judy"})
+	if err != nil {
+		t.Fatalf("inserting a sparse row failed: %v", err)
+	}
+	if row, err := table.GetRow(b2db, first); err != nil || row["username"] != "ivan" || row["age"] != int32(60) {
+		t.Errorf("first row was not stored under its own key: %v, %v", row, err)
+	}
+	row, err := table.GetRow(b2db, second)
+	if err != nil || row["username"] != "judy" {
+		t.Errorf("unexpected sparse row: %v, %v", row, err)
+	}
+	if _, ok := row["age"]; ok {
+		t.Errorf("missing column should be stored as NULL: %v", row)
+	}
+	if found, _ := table.LookupByIndex(b2db, "username", "judy"); len(found) != 1 {
+		t.Errorf("row inserted by map was not indexed: %v", found)
+	}
+	if _, err = table.InsertByMap(b2db, map[string]interface{}{"nope": 1}); err == nil {
+		t.Error("inserting an unknown column should fail")
+	}
+}
+
+func TestNullAndDefault(t *testing.T) {
+	cols := make([]B2Column, 3)
+	cols[0] = *NewColumn("device", "string").Length(32).Null(false)
+	cols[1] = *NewColumn("reading", "float64")
+	cols[2] = *NewColumn("unit", "string").Length(8).DefaultValue("C")
+	b2db := openTestDatabase(t, "nullsDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	table, err := NewTable("readings", cols, b2db, meta)
+	if err != nil {
+		t.Fatalf("creating readings failed: %v", err)
+	}
+	if table, err = b2db.GetTable("readings", meta); err != nil {
+		t.Fatal("get readings META failed")
+	}
+	rowKey, err := table.InsertByValues(b2db, "d1", nil, nil)
+	if err != nil {
+		t.Fatalf("inserting NULL values failed: %v", err)
+	}
+	row, err := table.GetRow(b2db, rowKey)
+	if err != nil || row["unit"] != "C" {
+		t.Errorf("default value was not applied: %v, %v", row, err)
+	}
+	if _, ok := row["reading"]; ok {
+		t.Errorf("NULL reading should be absent from the row: %v", row)
+	}
+	zeroKey, err := table.InsertByMap(b2db, map[string]interface{}{"device": "d2", "reading": 0.0})
+	if err != nil {
+		t.Fatalf("inserting a zero reading failed: %v", err)
+	}
+	var reading struct {
+		Reading *float64
+	}
+	if err = table.GetRowInto(b2db, rowKey, &reading); err != nil || reading.Reading != nil {
+		t.Errorf("NULL should map to a nil pointer: %v, %v", reading.Reading, err)
+	}
+	if err = table.GetRowInto(b2db, zeroKey, &reading); err != nil || reading.Reading == nil || *reading.Reading != 0 {
+		t.Errorf("zero should map to a pointer to zero: %v, %v", reading.Reading, err)
+	}
+	if _, err = table.InsertByMap(b2db, map[string]interface{}{"reading": 1.0}); err != ErrNotNull {
+		t.Errorf("expected ErrNotNull for a missing NOT NULL column, got %v", err)
+	}
+	if err = table.UpdateByKey(b2db, zeroKey, map[string]interface{}{"reading": nil}); err != nil {
+		t.Fatalf("updating to NULL failed: %v", err)
+	}
+	if row, _ = table.GetRow(b2db, zeroKey); row["reading"] != nil {
+		t.Errorf("reading should be NULL after update: %v", row)
+	}
+	if err = table.UpdateByKey(b2db, zeroKey, map[string]interface{}{"device": nil}); err != ErrNotNull {
+		t.Errorf("expected ErrNotNull when nulling a NOT NULL column, got %v", err)
+	}
+}
+
+func TestOldTableFormat(t *testing.T) {
+	b2db := openTestDatabase(t, "tableFormatDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	// 加入FormatVersion之前创建的表，META中没有版本号
+	for _, c := range []struct {
+		name string
+		col  *B2Column
+		err  error
+	}{
+		{"events", NewColumn("at", "timestamp"), ErrTableFormat},
+		{"users", NewColumn("age", "int32"), nil},
+	} {
+		old := &B2Table{
+			TableName:  c.name,
+			TableID:    xid.New().String(),
+			CreateTime: time.Now(),
+			Columns:    []B2Column{*NewColumn("name", "string"), *c.col},
+		}
+		if err := b2db.AddTable(old, meta); err != nil {
+			t.Fatalf("adding old table %s failed: %v", c.name, err)
+		}
+		if _, err := b2db.GetTable(c.name, meta); err != c.err {
+			t.Errorf("get old table %s: expected %v, got %v", c.name, c.err, err)
+		}
+	}
+	// 旧表仍然可以删除，也不妨碍保存和重建其他表的索引
+	if err := b2db.SaveIndexes(meta); err != nil {
+		t.Errorf("saving indexes with an old table failed: %v", err)
+	}
+	if err := b2db.RemoveTable("events", meta); err != nil {
+		t.Errorf("removing old table failed: %v", err)
+	}
+	if _, err := NewTable("events", []B2Column{*NewColumn("at", "timestamp")}, b2db, meta); err != nil {
+		t.Fatalf("recreating events failed: %v", err)
+	}
+	if _, err := b2db.GetTable("events", meta); err != nil {
+		t.Errorf("a new table with a timestamp column was refused: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package b2schema
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+// QueryTimeRange 查询时间轴字段落在 [start, end) 区间内的行，结果按时间升序排列
+// columns 为投影字段，为空时返回全部字段；时间轴字段总是包含在结果中
+func (t *B2Table) QueryTimeRange(db *B2Database, start, end time.Time, columns ...string) ([]Row, error) {
+	var rows []Row
+	err := t.QueryTimeRangeFunc(db, start, end, columns, func(row Row) bool {
+		rows = append(rows, row)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		ti, _ := rowKeyTime(rows[i].Key)
+		tj, _ := rowKeyTime(rows[j].Key)
+		return ti < tj
+	})
+	return rows, nil
+}
+
+// QueryTimeRangeFunc 逐行读取时间轴字段落在 [start, end) 区间内的行，fn返回false时停止
+// 同一序列内的行按时间顺序给出，不同序列之间不保证时间顺序
+func (t *B2Table) QueryTimeRangeFunc(db *B2Database, start, end time.Time, columns []string,
+	fn func(Row) bool) error {
+	byID, projected, err := t.timeProjection(columns)
+	if err != nil {
+		return err
+	}
+	it, release := db.newIterator(rdb.NewDefaultReadOptions())
+	defer release()
+	chunks, releaseChunks := t.openChunkReader(db)
+	defer releaseChunks()
+	// 逐个序列Seek到时间区间的起点，区间读完后直接跳到下一个序列
+	prefix := t.keyPrefix()
+	it.Seek(prefix)
+	for it.ValidForPrefix(prefix) {
+		key := it.Key()
+		rowKey, _, ok := splitRowColumnKey(string(key.Data()))
+		key.Free()
+		var series []byte
+		if ok {
+			series, ok = rowKeySeriesPrefix(rowKey)
+		}
+		if !ok {
+			it.Next()
+			continue
+		}
+		more, err := t.seriesTimeRange(it, chunks, series, start, end, byID, projected, fn)
+		if err != nil || !more {
+			return err
+		}
+		next := prefixSuccessor(series)
+		if next == nil {
+			break
+		}
+		it.Seek(next)
+	}
+	if err = it.Err(); err != nil {
+		log.Printf("扫描表 %s 时发生错误: %v\n", t.TableName, err)
+		return err
+	}
+	return nil
+}
+
+// QuerySeriesTimeRange 查询某一个序列中时间轴字段落在 [start, end) 区间内的行，结果按时间升序排列
+// series 为SeriesColumn字段的值，表没有定义序列字段时应传入nil
+func (t *B2Table) QuerySeriesTimeRange(db *B2Database, series interface{}, start, end time.Time,
+	columns ...string) ([]Row, error) {
+	byID, projected, err := t.timeProjection(columns)
+	if err != nil {
+		return nil, err
+	}
+	var seriesValue []byte
+	if len(t.SeriesColumn) > 0 {
+		var found bool
+		if seriesValue, found, err = t.column(t.SeriesColumn).lookupBytes(series); err != nil {
+			return nil, err
+		}
+		if !found {
+			// 字典中没有的序列值不可能有数据
+			return nil, nil
+		}
+	}
+	it, release := db.newIterator(rdb.NewDefaultReadOptions())
+	defer release()
+	chunks, releaseChunks := t.openChunkReader(db)
+	defer releaseChunks()
+	var rows []Row
+	_, err = t.seriesTimeRange(it, chunks, t.seriesPrefix(seriesValue), start, end, byID, projected, func(row Row) bool {
+		rows = append(rows, row)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// seriesTimeRange 在一个序列前缀内读取 [start, end) 区间的行，行按时间顺序交给fn
+func (t *B2Table) seriesTimeRange(it *rdb.Iterator, chunks *chunkReader, series []byte, start, end time.Time,
+	byID map[string]*B2Column, projected map[string]bool, fn func(Row) bool) (bool, error) {
+	lower := append(append([]byte(nil), series...), encodeKeyTime(start.UnixNano())...)
+	upper := append(append([]byte(nil), series...), encodeKeyTime(end.UnixNano())...)
+	it.Seek(lower)
+	return t.collectRows(it, byID, projected, chunks, func(key []byte) bool {
+		return bytes.Compare(key, upper) < 0
+	}, fn)
+}
+
+// timeProjection 生成时间范围查询的投影，投影中总是包含时间轴字段
+func (t *B2Table) timeProjection(columns []string) (map[string]*B2Column, map[string]bool, error) {
+	if len(t.TimeColumn) == 0 {
+		log.Printf("表 %s 没有定义时间轴字段\n", t.TableName)
+		return nil, nil, errors.New("table has no time column")
+	}
+	if len(columns) > 0 {
+		columns = append(columns[:len(columns):len(columns)], t.TimeColumn)
+	}
+	return t.projection(columns)
+}
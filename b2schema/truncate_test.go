@@ -0,0 +1,83 @@
+package b2schema
+
+import (
+	"testing"
+
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+func TestTruncateTable(t *testing.T) {
+	b2db := openTestDatabase(t, "truncateDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	before := newUsersTable(t, b2db, "alice", "bob")
+	if err := b2db.TruncateTable("users", meta); err != nil {
+		t.Fatalf("truncating users failed: %v", err)
+	}
+	table, err := b2db.GetTable("users", meta)
+	if err != nil || table.TableID != before.TableID || table.column("username").IndexID != before.column("username").IndexID {
+		t.Fatalf("truncate should keep table and index IDs: %v, %v", table, err)
+	}
+	if rows, _, err := table.Scan(b2db, ScanOptions{}); err != nil || len(rows) != 0 {
+		t.Errorf("rows left after truncate: %v, %v", rows, err)
+	}
+	if n := b2db.Indexes.IDIndexLen(table.TableID); n != 0 {
+		t.Errorf("expected an empty ID index after truncate, got %d", n)
+	}
+	if _, err = table.InsertByValues(b2db, "erin", int32(25)); err != nil {
+		t.Fatalf("inserting after truncate failed: %v", err)
+	}
+	if found, _ := table.LookupByIndex(b2db, "username", "erin"); len(found) != 1 {
+		t.Errorf("lookup after truncate failed: %v", found)
+	}
+}
+
+func TestTruncateKeepsDictionaryIDs(t *testing.T) {
+	b2db := openTestDatabase(t, "truncateDictDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	cols := []B2Column{*NewColumn("host", "string").Length(32).Dict(true)}
+	table, err := NewTable("hosts", cols, b2db, meta)
+	if err != nil {
+		t.Fatalf("creating hosts failed: %v", err)
+	}
+	insert := func(host string) []byte {
+		key, err := table.InsertByValues(b2db, host)
+		if err != nil {
+			t.Fatalf("inserting %s failed: %v", host, err)
+		}
+		slice, err := b2db.RocksDbWriteConn.Get(rdb.NewDefaultReadOptions(), rowColumnKey(key, table.column("host").ColumnID))
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", host, err)
+		}
+		defer slice.Free()
+		return append([]byte(nil), slice.Data()...)
+	}
+	insert("web-01")
+	insert("web-02")
+	if err = b2db.TruncateTable("hosts", meta); err != nil {
+		t.Fatalf("truncating hosts failed: %v", err)
+	}
+	// 清空后分配的ID不能与清空前的重复，重新打开数据库后也一样
+	if id := insert("web-03"); len(id) != 1 || id[0] != 2 {
+		t.Errorf("dictionary IDs should not be reused after truncate, got %v", id)
+	}
+	b2db.Close()
+	if b2db, err = b2db.OpenConnection(meta.Config); err != nil {
+		t.Fatalf("reopening truncateDictDB failed: %v", err)
+	}
+	if table, err = b2db.GetTable("hosts", meta); err != nil {
+		t.Fatalf("get hosts META failed: %v", err)
+	}
+	if err = b2db.TruncateTable("hosts", meta); err != nil {
+		t.Fatalf("truncating hosts again failed: %v", err)
+	}
+	b2db.Close()
+	if b2db, err = b2db.OpenConnection(meta.Config); err != nil {
+		t.Fatalf("reopening truncateDictDB failed: %v", err)
+	}
+	if table, err = b2db.GetTable("hosts", meta); err != nil {
+		t.Fatalf("get hosts META failed: %v", err)
+	}
+	if id := insert("web-04"); len(id) != 1 || id[0] != 3 {
+		t.Errorf("dictionary IDs should not be reused after reopening, got %v", id)
+	}
+}
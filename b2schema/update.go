@@ -0,0 +1,115 @@
+package b2schema
+
+import (
+	"bytes"
+	"errors"
+	"log"
+
+	rdb "github.com/tecbot/gorocksdb"
+)
+
+// UpdateByKey 按行键修改一行中若干字段的值，values为字段名称->新值，新值为nil表示改为NULL
+// 所有字段在同一个事务中改写，时间字段和序列字段参与生成行键，不能修改
+// 字段索引中值发生变化的条目在事务提交后移动到新值下
+func (t *B2Table) UpdateByKey(db *B2Database, rowKey string, values map[string]interface{}) error {
+	if len(values) == 0 {
+		return nil
+	}
+	encoded := make(map[string][]byte, len(values))
+	for name, value := range values {
+		col := t.column(name)
+		if col == nil {
+			log.Printf("表 %s 中不存在字段 %s\n", t.TableName, name)
+			return errors.New("no such column")
+		}
+		if name == t.TimeColumn || name == t.SeriesColumn {
+			log.Printf("表 %s 的字段 %s 参与生成行键，不能修改\n", t.TableName, name)
+			return errors.New("row key columns cannot be updated")
+		}
+		if value == nil {
+			// 修改为NULL即删除字段值
+			if col.NotNull {
+				log.Printf("表 %s 的字段 %s 不允许NULL\n", t.TableName, name)
+				return ErrNotNull
+			}
+			encoded[name] = nil
+			continue
+		}
+		colValue, err := col.FormatBytes(value)
+		if err != nil {
+			log.Printf("字段定义与值类型转换出错: %v\n", err)
+			return err
+		}
+		encoded[name] = colValue
+	}
+	wopts := rdb.NewDefaultWriteOptions()
+	topts := rdb.NewDefaultTransactionOptions()
+	txn := db.RocksDbWriteConn.TransactionBegin(wopts, topts, nil)
+	defer txn.Destroy()
+	old, err := t.lockRow(txn, rowKey)
+	if err != nil {
+		_ = txn.Rollback()
+		return err
+	}
+	chunks := t.txnChunkReader(txn)
+	before := make(map[string][]byte)
+	after := make(map[string][]byte)
+	for name, colValue := range encoded {
+		col := t.column(name)
+		key := rowColumnKey(rowKey, col.ColumnID)
+		if colValue == nil {
+			err = txn.Delete(key)
+		} else {
+			err = writeKV(key, colValue, txn)
+		}
+		if err == nil {
+			// 压缩块中的旧值要一起删除，否则单独存储的值被改为NULL后压缩块中的旧值会重新出现
+			err = chunks.removePoint(col, rowKey)
+		}
+		if err != nil {
+			log.Printf("写入字段数据时发生错误: %v\n", err)
+			_ = txn.Rollback()
+			return err
+		}
+		prev, ok := old[name]
+		if ok && colValue != nil && bytes.Equal(prev, colValue) {
+			continue
+		}
+		if ok {
+			before[name] = prev
+		}
+		if colValue != nil {
+			after[name] = colValue
+		}
+	}
+	ops := newIndexOps(t)
+	ops.updateRow(rowKey, before, after)
+	if err = ops.commit(txn, db); err != nil {
+		log.Printf("提交事务时发生错误: %v\n", err)
+		_ = txn.Rollback()
+		return err
+	}
+	return nil
+}
+
+// lockRow 在事务中读取并锁定一行的全部字段，返回字段名称->编码后的值
+// 行中任何一个字段都不存在时返回ErrRowNotFound
+func (t *B2Table) lockRow(txn *rdb.Transaction, rowKey string) (map[string][]byte, error) {
+	ropts := rdb.NewDefaultReadOptions()
+	encoded := make(map[string][]byte, len(t.Columns))
+	for _, col := range t.Columns {
+		slice, err := txn.GetForUpdate(ropts, rowColumnKey(rowKey, col.ColumnID))
+		if err != nil {
+			log.Printf("读取表 %s 行 %s 字段 %s 时发生错误: %v\n", t.TableName, rowKey, col.ColumnName, err)
+			return nil, err
+		}
+		if slice.Exists() {
+			encoded[col.ColumnName] = append([]byte(nil), slice.Data()...)
+		}
+		slice.Free()
+	}
+	if len(encoded) == 0 {
+		return nil, ErrRowNotFound
+	}
+	return encoded, nil
+}
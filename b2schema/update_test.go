@@ -0,0 +1,38 @@
+package b2schema
+
+import (
+	"testing"
+)
+
+func TestUpdateByKey(t *testing.T) {
+	b2db := openTestDatabase(t, "updateDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	table := newUsersTable(t, b2db, "alice", "bob")
+	found, err := table.LookupByIndex(b2db, "username", "bob")
+	if err != nil || len(found) != 1 {
+		t.Fatalf("lookup of bob failed: %v, %v", found, err)
+	}
+	rowKey := found[0].Key
+	if err = table.UpdateByKey(b2db, rowKey, map[string]interface{}{"username": "bobby", "age": int32(41)}); err != nil {
+		t.Fatalf("updating row %s failed: %v", rowKey, err)
+	}
+	row, err := table.GetRow(b2db, rowKey)
+	if err != nil || row["username"] != "bobby" || row["age"] != int32(41) {
+		t.Errorf("unexpected row after update: %v, %v", row, err)
+	}
+	if found, _ = table.LookupByIndex(b2db, "username", "bob"); len(found) != 0 {
+		t.Errorf("old index entry was not removed: %v", found)
+	}
+	if found, _ = table.LookupByIndex(b2db, "username", "bobby"); len(found) != 1 || found[0].Key != rowKey {
+		t.Errorf("new index entry is missing: %v", found)
+	}
+	if err = table.UpdateByKey(b2db, rowKey, map[string]interface{}{"age": "old"}); err == nil {
+		t.Error("updating with a mismatched value should fail")
+	}
+	if err = table.UpdateByKey(b2db, rowKey, map[string]interface{}{"nope": 1}); err == nil {
+		t.Error("updating an unknown column should fail")
+	}
+	if err = table.UpdateByKey(b2db, "no-such-row", map[string]interface{}{"age": int32(1)}); err != ErrRowNotFound {
+		t.Errorf("expected ErrRowNotFound, got %v", err)
+	}
+}
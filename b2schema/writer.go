@@ -0,0 +1,192 @@
+package b2schema
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// 缓冲写入的默认参数
+const (
+	defaultWriterBatchSize     = 1000
+	defaultWriterFlushInterval = time.Second
+)
+
+var (
+	// ErrWriterFull 缓冲区已满并且没有设置阻塞等待
+	ErrWriterFull = errors.New("writer buffer is full")
+	// ErrWriterClosed 缓冲写入已经关闭
+	ErrWriterClosed = errors.New("writer is closed")
+)
+
+// WriterOptions 缓冲写入参数
+type WriterOptions struct {
+	// BatchSize 缓冲达到多少行时写入一批，默认1000
+	BatchSize int
+	// FlushInterval 最长多久写入一次，默认1秒
+	FlushInterval time.Duration
+	// BufferSize 等待写入的行数上限，默认为BatchSize的4倍
+	BufferSize int
+	// Block 缓冲区满时Write阻塞等待，为false时返回ErrWriterFull
+	Block bool
+	// Mode 每一批的写入模式，默认BatchFailAll
+	Mode BatchMode
+	// OnError 后台写入失败时的回调，可以为nil
+	OnError func(results []BatchResult, err error)
+}
+
+// Writer 表的缓冲写入，可以被多个goroutine同时调用，按行数或时间间隔批量写入
+type Writer struct {
+	table  *B2Table
+	db     *B2Database
+	opts   WriterOptions
+	points chan []interface{}
+	flush  chan chan error
+	done   chan error
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewWriter 为表创建一个缓冲写入并启动后台写入，使用完毕后必须调用Close
+func (t *B2Table) NewWriter(db *B2Database, opts WriterOptions) *Writer {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultWriterBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultWriterFlushInterval
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 4 * opts.BatchSize
+	}
+	w := &Writer{
+		table:  t,
+		db:     db,
+		opts:   opts,
+		points: make(chan []interface{}, opts.BufferSize),
+		flush:  make(chan chan error),
+		done:   make(chan error, 1),
+	}
+	go w.run()
+	return w
+}
+
+// Write 缓冲一行数据，值按字段顺序排列。数据的校验和写入在后台进行，错误通过OnError、Flush或Close返回
+// values会被复制，调用返回后调用方可以重用同一个切片
+func (w *Writer) Write(values ...interface{}) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrWriterClosed
+	}
+	row := append([]interface{}(nil), values...)
+	if w.opts.Block {
+		w.points <- row
+		return nil
+	}
+	select {
+	case w.points <- row:
+		return nil
+	default:
+		return ErrWriterFull
+	}
+}
+
+// Flush 立即写入已经缓冲的全部数据，返回这次写入的错误
+func (w *Writer) Flush() error {
+	reply := make(chan error, 1)
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return ErrWriterClosed
+	}
+	w.flush <- reply
+	w.mu.RUnlock()
+	return <-reply
+}
+
+// Close 写入剩余的数据并停止后台写入，返回最后一次写入的错误
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrWriterClosed
+	}
+	w.closed = true
+	// 持有写锁时没有goroutine在发送，可以安全关闭
+	close(w.points)
+	w.mu.Unlock()
+	return <-w.done
+}
+
+// run 后台写入循环
+func (w *Writer) run() {
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+	buf := make([][]interface{}, 0, w.opts.BatchSize)
+	for {
+		select {
+		case values, ok := <-w.points:
+			if !ok {
+				w.done <- w.write(buf)
+				return
+			}
+			buf = append(buf, values)
+			if len(buf) >= w.opts.BatchSize {
+				w.write(buf)
+				buf = buf[:0]
+			}
+		case <-ticker.C:
+			if len(buf) > 0 {
+				w.write(buf)
+				buf = buf[:0]
+			}
+		case reply := <-w.flush:
+			// 把Flush之前已经进入缓冲区的数据一起写入
+			closed := false
+			for drained := false; !drained; {
+				select {
+				case values, ok := <-w.points:
+					if !ok {
+						closed = true
+						drained = true
+						continue
+					}
+					buf = append(buf, values)
+				default:
+					drained = true
+				}
+			}
+			err := w.write(buf)
+			buf = buf[:0]
+			reply <- err
+			if closed {
+				w.done <- nil
+				return
+			}
+		}
+	}
+}
+
+// write 写入一批数据，写入失败时调用OnError
+func (w *Writer) write(buf [][]interface{}) error {
+	if len(buf) == 0 {
+		return nil
+	}
+	results, err := w.table.InsertBatch(w.db, buf, w.opts.Mode)
+	if err == nil {
+		for _, r := range results {
+			if r.Err != nil {
+				err = r.Err
+				break
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("缓冲写入表 %s 时发生错误: %v\n", w.table.TableName, err)
+		if w.opts.OnError != nil {
+			w.opts.OnError(results, err)
+		}
+	}
+	return err
+}
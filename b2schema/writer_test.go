@@ -0,0 +1,76 @@
+package b2schema
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	b2db := openTestDatabase(t, "writerDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	table := newUsersTable(t, b2db, "alice")
+	before := b2db.Indexes.IDIndexLen(table.TableID)
+	var failed int
+	w := table.NewWriter(b2db, WriterOptions{
+		BatchSize: 4,
+		Block:     true,
+		Mode:      BatchSkipInvalid,
+		OnError:   func([]BatchResult, error) { failed++ },
+	})
+	var wg sync.WaitGroup
+	for g := 0; g < 5; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2; i++ {
+				if err := w.Write("writer", int32(g*10+i)); err != nil {
+					t.Errorf("buffered write failed: %v", err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if err := w.Flush(); err != nil {
+		t.Errorf("flushing writer failed: %v", err)
+	}
+	err := w.Write("writer", "not an int32")
+	if err != nil {
+		t.Errorf("buffered write failed: %v", err)
+	}
+	if err = w.Close(); err == nil || failed != 1 {
+		t.Errorf("expected the invalid row to be reported once, got %v and %d callbacks", err, failed)
+	}
+	if n := b2db.Indexes.IDIndexLen(table.TableID); n != before+10 {
+		t.Errorf("expected %d row IDs after buffered writes, got %d", before+10, n)
+	}
+	if err = w.Write("writer", int32(1)); err != ErrWriterClosed {
+		t.Errorf("expected ErrWriterClosed, got %v", err)
+	}
+}
+
+func TestWriterCopiesValues(t *testing.T) {
+	b2db := openTestDatabase(t, "writerCopyDB")
+	defer func() { dropTestDatabase(t, b2db) }()
+	cols := []B2Column{*NewColumn("username", "string").Length(100).Index(true)}
+	table, err := NewTable("users", cols, b2db, meta)
+	if err != nil {
+		t.Fatalf("creating users failed: %v", err)
+	}
+	w := table.NewWriter(b2db, WriterOptions{BatchSize: 16, Block: true})
+	row := make([]interface{}, 1)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		// 同一个切片重复使用，写入的必须是调用Write时的值
+		row[0] = name
+		if err = w.Write(row...); err != nil {
+			t.Fatalf("buffered write failed: %v", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("closing writer failed: %v", err)
+	}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if found, err := table.LookupByIndex(b2db, "username", name); err != nil || len(found) != 1 {
+			t.Errorf("lookup of %s failed: %v, %v", name, found, err)
+		}
+	}
+}
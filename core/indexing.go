@@ -2,12 +2,13 @@ package core
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"log"
-	"encoding/binary"
+	"math"
+	"time"
 
 	"github.com/google/btree"
-	schema "github.com/babydb/babydb/b2schema"
 )
 
 // IDIndex ID字段索引类型
@@ -27,9 +28,9 @@ type NormalIndex struct {
 // Index 索引的接口
 type Index interface {
 	Less(item btree.Item) bool
-	InsertOpIndexing(ID string)
-	DeleteOpIndexing(ID string)
-	Serialize(ID string) []byte
+	InsertOpIndexing(m *IndexManager, ID string)
+	DeleteOpIndexing(m *IndexManager, ID string)
+	Serialize(m *IndexManager, ID string) ([]byte, error)
 }
 
 // Less NormalIndex实现btree Item接口
@@ -48,6 +49,8 @@ func (a NormalIndex) Less(b btree.Item) bool {
 		return a.Value.(string) < bi.Value.(string)
 	case []byte:
 		return byteLess(a.Value.([]byte), bi.Value.([]byte))
+	case time.Time:
+		return a.Value.(time.Time).Before(bi.Value.(time.Time))
 	}
 	return false
 }
@@ -68,57 +71,116 @@ func byteLess(a, b []byte) bool {
 	return false
 }
 
-// IDIndice row key ID的索引map
-var IDIndice = make(map[string]*btree.BTree, 10)
-
-// NormalIndice 普通字段的索引map
-var NormalIndice = make(map[string]*btree.BTree, 10)
-
 // InsertOpIndexing 插入数据时更新ID字段索引
-func (id IDIndex) InsertOpIndexing(tableID string) {
-	tree, ok := IDIndice[tableID]
+func (id IDIndex) InsertOpIndexing(m *IndexManager, tableID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tree, ok := m.ids[tableID]
 	if !ok {
-		tree = btree.New(64)
+		tree = NewIndexTree()
+		m.ids[tableID] = tree
 	}
 	tree.ReplaceOrInsert(id)
-	IDIndice[tableID] = tree
 }
 
 // InsertOpIndexing 插入数据时更新普通字段索引
-func (a NormalIndex) InsertOpIndexing(indexID string) {
-	tree, ok := NormalIndice[indexID]
+func (a NormalIndex) InsertOpIndexing(m *IndexManager, indexID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tree, ok := m.normal[indexID]
 	if !ok {
-		tree = btree.New(64)
+		tree = NewIndexTree()
+		m.normal[indexID] = tree
 	}
-	if item := tree.Get(a); item != nil {
-		node := item.(*NormalIndex)
-		node.UID = append(node.UID, a.UID...)
-	} else {
-		tree.ReplaceOrInsert(a)
+	a.InsertInto(tree)
+}
+
+// InsertInto 将a.UID中的行ID加入tree中值为a.Value的节点，已经存在的行ID不会重复加入
+func (a NormalIndex) InsertInto(tree *btree.BTree) {
+	item := tree.Get(a)
+	if item == nil {
+		node := NormalIndex{Value: a.Value, UID: make([]string, len(a.UID))}
+		copy(node.UID, a.UID)
+		tree.ReplaceOrInsert(node)
+		return
 	}
+	node := item.(NormalIndex)
+	exists := make(map[string]bool, len(node.UID))
+	for _, id := range node.UID {
+		exists[id] = true
+	}
+	uids := make([]string, len(node.UID), len(node.UID)+len(a.UID))
+	copy(uids, node.UID)
+	for _, id := range a.UID {
+		if !exists[id] {
+			exists[id] = true
+			uids = append(uids, id)
+		}
+	}
+	node.UID = uids
+	tree.ReplaceOrInsert(node)
 }
 
 // DeleteOpIndexing 删除数据时更新ID字段索引
-func (id IDIndex) DeleteOpIndexing(tableID string) {
-	if IDIndice[tableID] == nil {
-		return
+func (id IDIndex) DeleteOpIndexing(m *IndexManager, tableID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if tree := m.ids[tableID]; tree != nil {
+		tree.Delete(id)
 	}
-	IDIndice[tableID].Delete(id)
 }
 
-// DeleteOpIndexing 删除数据时跟新普通字段索引
-func (a NormalIndex) DeleteOpIndexing(indexID string) {
-	if NormalIndice[indexID] == nil {
+// DeleteOpIndexing 删除数据时更新普通字段索引，从值节点中移除a.UID中的行ID，节点为空时删除节点
+func (a NormalIndex) DeleteOpIndexing(m *IndexManager, indexID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if tree := m.normal[indexID]; tree != nil {
+		a.DeleteFrom(tree)
+	}
+}
+
+// DeleteFrom 从tree中值为a.Value的节点移除a.UID中的行ID，节点为空时删除节点
+func (a NormalIndex) DeleteFrom(tree *btree.BTree) {
+	item := tree.Get(a)
+	if item == nil {
+		return
+	}
+	node := item.(NormalIndex)
+	removed := make(map[string]bool, len(a.UID))
+	for _, id := range a.UID {
+		removed[id] = true
+	}
+	uids := make([]string, 0, len(node.UID))
+	for _, id := range node.UID {
+		if !removed[id] {
+			uids = append(uids, id)
+		}
+	}
+	if len(uids) == 0 {
+		tree.Delete(node)
 		return
 	}
-	NormalIndice[indexID].Delete(a)
+	node.UID = uids
+	tree.ReplaceOrInsert(node)
 }
 
+// NewIndexTree 新建一棵空的索引树
+func NewIndexTree() *btree.BTree {
+	return btree.New(64)
+}
+
+var (
+	errTableNotFound = errors.New("table ID not found")
+	errIndexNotFound = errors.New("index ID not found")
+)
+
 // Serialize 将ID索引的Btree序列化为byte数组
-func (id IDIndex) Serialize(tableID string) ([]byte, error) {
-	tree, ok := IDIndice[tableID]
+func (id IDIndex) Serialize(m *IndexManager, tableID string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tree, ok := m.ids[tableID]
 	if !ok {
-		return nil, errors.New("table ID not found")
+		return nil, errTableNotFound
 	}
 	var buf bytes.Buffer
 	tree.Ascend(idTraverse(&buf))
@@ -128,10 +190,12 @@ func (id IDIndex) Serialize(tableID string) ([]byte, error) {
 }
 
 // Serialize 将普通字段索引的Btree序列化为byte数组
-func (a NormalIndex) Serialize(indexID string) ([]byte, error) {
-	tree, ok := NormalIndice[indexID]
+func (a NormalIndex) Serialize(m *IndexManager, indexID string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tree, ok := m.normal[indexID]
 	if !ok {
-		return nil, errors.New("index ID not found")
+		return nil, errIndexNotFound
 	}
 	var buf bytes.Buffer
 	tree.Ascend(normalTraverse(&buf))
@@ -140,63 +204,130 @@ func (a NormalIndex) Serialize(indexID string) ([]byte, error) {
 	return treeBytes, nil
 }
 
-// IdIndexDeserialize 将一个byte数组反序列化未一个ID索引
+// 普通字段索引序列化时值的类型标记
+const (
+	tagInt32   = byte(iota + 1) // int32
+	tagInt64                    // int64
+	tagFloat32                  // float32
+	tagFloat64                  // float64
+	tagString                   // string
+	tagBytes                    // []byte
+	tagTime                     // time.Time，保存为Unix纳秒数
+)
+
+// IdIndexDeserialize 将一个byte数组反序列化为一个ID索引
 func IdIndexDeserialize(treeBytes []byte) (*btree.BTree, error) {
 	bsLen := len(treeBytes)
 	if bsLen == 0 {
 		return nil, errors.New("empty bytes to deserialize")
 	}
 	tree := btree.New(64)
-	for p:=0; p<bsLen; {
-		hl, size := binary.Varint(treeBytes[p:])
-		if size == 0 {
-			log.Fatalf("读取Varint时发生错误，字节缓冲区长度不足\n")
-			break
-		}
-		if size < 0 {
-			log.Printf("读取到的Varint长度值超过64bit，该长度忽略")
-			p += size
-			continue
+	for p := 0; p < bsLen; {
+		id, size, err := readChunk(treeBytes[p:])
+		if err != nil {
+			log.Printf("反序列化ID索引时发生错误: %v\n", err)
+			return nil, err
 		}
+		tree.ReplaceOrInsert(IDIndex(id))
 		p += size
-		if p+hl>bsLen {
-			log.Fatalf("ID数据长度范围超出字节总长度范围: %d > %d\n", p+hl, bsLen)
-			break
-		}
-		id := treeBytes[p: p+hl].(IDIndex)
-		tree.ReplaceOrInsert(id)
-		p += hl
 	}
 	return tree, nil
 }
 
+// NormalIndexDeserialize 将一个byte数组反序列化为一个普通字段索引
+// 每个节点的格式: 类型标记(1字节) + varint长度 + 值 + varint行ID个数 + (varint长度 + 行ID)*
 func NormalIndexDeserialize(treeBytes []byte) (*btree.BTree, error) {
 	bsLen := len(treeBytes)
 	if bsLen == 0 {
 		return nil, errors.New("empty bytes to deserialize")
 	}
 	tree := btree.New(64)
-	for p:=0; p<bsLen; {
-		hl, size := binary.Varint(treeBytes[p:])
-		if size == 0 {
-			log.Fatalf("读取Varint时发生错误，字节缓冲区长度不足\n")
-			break
-		}
-		if size < 0 {
-			log.Printf("读取到的Varint长度值超过64bit，该长度忽略")
+	for p := 0; p < bsLen; {
+		tag := treeBytes[p]
+		p++
+		raw, size, err := readChunk(treeBytes[p:])
+		if err != nil {
+			log.Printf("反序列化普通字段索引时发生错误: %v\n", err)
+			return nil, err
+		}
+		p += size
+		value, err := decodeValue(tag, raw)
+		if err != nil {
+			log.Printf("反序列化普通字段索引时发生错误: %v\n", err)
+			return nil, err
+		}
+		count, size := binary.Varint(treeBytes[p:])
+		if size <= 0 || count < 0 {
+			return nil, errors.New("invalid UID count")
+		}
+		p += size
+		node := NormalIndex{Value: value, UID: make([]string, 0, count)}
+		for j := int64(0); j < count; j++ {
+			id, size, err := readChunk(treeBytes[p:])
+			if err != nil {
+				log.Printf("反序列化普通字段索引时发生错误: %v\n", err)
+				return nil, err
+			}
+			node.UID = append(node.UID, string(id))
 			p += size
-			continue
 		}
-		
+		tree.ReplaceOrInsert(node)
+	}
+	return tree, nil
+}
+
+// readChunk 读取一个 varint长度 + 数据 的片段，返回数据和片段总长度
+func readChunk(bs []byte) ([]byte, int, error) {
+	hl, size := binary.Varint(bs)
+	if size == 0 {
+		return nil, 0, errors.New("buffer too small to read varint")
+	}
+	if size < 0 {
+		return nil, 0, errors.New("varint overflows 64 bits")
+	}
+	if hl < 0 || int64(len(bs)-size) < hl {
+		return nil, 0, errors.New("chunk length out of range")
+	}
+	end := size + int(hl)
+	return bs[size:end], end, nil
+}
+
+// decodeValue 根据类型标记还原普通字段索引的值
+func decodeValue(tag byte, raw []byte) (interface{}, error) {
+	switch tag {
+	case tagInt32:
+		if len(raw) == 4 {
+			return int32(binary.LittleEndian.Uint32(raw)), nil
+		}
+	case tagInt64:
+		if len(raw) == 8 {
+			return int64(binary.LittleEndian.Uint64(raw)), nil
+		}
+	case tagFloat32:
+		if len(raw) == 4 {
+			return math.Float32frombits(binary.LittleEndian.Uint32(raw)), nil
+		}
+	case tagFloat64:
+		if len(raw) == 8 {
+			return math.Float64frombits(binary.LittleEndian.Uint64(raw)), nil
+		}
+	case tagString:
+		return string(raw), nil
+	case tagBytes:
+		return append([]byte(nil), raw...), nil
+	case tagTime:
+		if len(raw) == 8 {
+			return time.Unix(0, int64(binary.LittleEndian.Uint64(raw))).UTC(), nil
+		}
+	default:
+		return nil, errors.New("unknown value type tag")
 	}
+	return nil, errors.New("value length does not match its type")
 }
 
 func idTraverse(buf *bytes.Buffer) btree.ItemIterator {
 	return func(i btree.Item) bool {
-		lenBuf := make([]byte, 8)
-		hl := binary.PutVarint(lenBuf, len(i.(IDIndex)))
-		buf.Write(lenBuf[:hl])
-		buf.Write(i.(IDIndex))
+		writeChunk(buf, i.(IDIndex))
 		return true
 	}
 }
@@ -208,44 +339,52 @@ func normalTraverse(buf *bytes.Buffer) btree.ItemIterator {
 			log.Fatalf("节点不是普通索引，btree类型错误。")
 			return false
 		}
-		lenBuf := make([]byte, 8)
-		var hl int
-		switch data.Value.(type) {
+		raw := make([]byte, 8)
+		switch v := data.Value.(type) {
 		case int32:
-			hl = binary.PutVarint(lenBuf, (int64) 4)
-			buf.Write(lenBuf[:hl])
-			buf.Write(schema.Int32ToBytes(data.Value.(int32)))
+			binary.LittleEndian.PutUint32(raw, uint32(v))
+			buf.WriteByte(tagInt32)
+			writeChunk(buf, raw[:4])
 		case int64:
-			hl = binary.PutVarint(lenBuf, (int64) 8)
-			buf.Write(lenBuf[:hl])
-			buf.Write(schema.Int64ToBytes(data.Value.(int64)))
+			binary.LittleEndian.PutUint64(raw, uint64(v))
+			buf.WriteByte(tagInt64)
+			writeChunk(buf, raw)
 		case float32:
-			hl = binary.PutVarint(lenBuf, (int64) 4)
-			buf.Write(lenBuf[:hl])
-			buf.Write(schema.Float32ToBytes(data.Value.(float32)))
+			binary.LittleEndian.PutUint32(raw, math.Float32bits(v))
+			buf.WriteByte(tagFloat32)
+			writeChunk(buf, raw[:4])
 		case float64:
-			hl = binary.PutVarint(lenBuf, (int64) 8)
-			buf.Write(lenBuf[:hl])
-			buf.Write(schema.Float64ToBytes(data.Value.(float64)))
+			binary.LittleEndian.PutUint64(raw, math.Float64bits(v))
+			buf.WriteByte(tagFloat64)
+			writeChunk(buf, raw)
 		case string:
-			hl = binary.PutVarint(lenBuf, (int64) len(([]byte) data.Value.(string)))
-			buf.Write(lenBuf[:hl])
-			buf.Write(([]byte)data.Value.(string))
+			buf.WriteByte(tagString)
+			writeChunk(buf, []byte(v))
 		case []byte:
-			hl = binary.PutVarint(lenBuf, (int64) len(data.Value.([]byte)))
-			buf.Write(lenBuf[:hl])
-			buf.Write(data.Value.([]byte))
+			buf.WriteByte(tagBytes)
+			writeChunk(buf, v)
+		case time.Time:
+			binary.LittleEndian.PutUint64(raw, uint64(v.UnixNano()))
+			buf.WriteByte(tagTime)
+			writeChunk(buf, raw)
 		default:
-			log.Printf("节点数据类型不可识别：%t\n", data.Value)
+			log.Printf("节点数据类型不可识别：%T\n", data.Value)
 			return false
 		}
-		hl = binary.PutVarint(lenBuf, (int64) len(data.UID))
+		lenBuf := make([]byte, binary.MaxVarintLen64)
+		hl := binary.PutVarint(lenBuf, int64(len(data.UID)))
 		buf.Write(lenBuf[:hl])
 		for _, id := range data.UID {
-			hl = binary.PutVarint(lenBuf, (int64) len(([]byte)id))
-			buf.Write(lenBuf[:hl])
-			buf.Write(([]byte)id)
+			writeChunk(buf, []byte(id))
 		}
+		return true
 	}
 }
 
+// writeChunk 写入一个 varint长度 + 数据 的片段
+func writeChunk(buf *bytes.Buffer, data []byte) {
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	hl := binary.PutVarint(lenBuf, int64(len(data)))
+	buf.Write(lenBuf[:hl])
+	buf.Write(data)
+}
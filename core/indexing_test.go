@@ -2,6 +2,7 @@ package core
 
 import (
 	"bytes"
+	"sync"
 	"testing"
 
 	"github.com/rs/xid"
@@ -10,15 +11,80 @@ import (
 )
 
 func TestIDIndexing(t *testing.T) {
+	m := NewIndexManager()
 	for i := 0; i < 10000000; i++ {
 		a := IDIndex(xid.New().String())
-		a.InsertOpIndexing("testTable")
+		a.InsertOpIndexing(m, "testTable")
 	}
 	// var b bytes.Buffer
-	// IDIndice["testTable"].Ascend(tTraverse(&b))
+	// m.ids["testTable"].Ascend(tTraverse(&b))
 	// fmt.Println(b.String())
 }
 
+func TestNormalIndexLookup(t *testing.T) {
+	m := NewIndexManager()
+	for i, v := range []int64{30, 10, 20, 10} {
+		NormalIndex{Value: v, UID: []string{xid.New().String()}}.InsertOpIndexing(m, "testIndex")
+		if i == 0 && m.NormalIndexLen("testIndex") != 1 {
+			t.Fatal("index tree was not registered")
+		}
+	}
+	if uids := m.LookupEqual("testIndex", int64(10)); len(uids) != 2 {
+		t.Errorf("expected 2 rows with value 10, got %v", uids)
+	}
+	if uids := m.LookupRange("testIndex", int64(15), int64(30)); len(uids) != 1 {
+		t.Errorf("expected 1 row in [15, 30), got %v", uids)
+	}
+	if uids := m.LookupRange("testIndex", nil, nil); len(uids) != 4 {
+		t.Errorf("expected all 4 rows, got %v", uids)
+	}
+	if uids := m.LookupEqual("noSuchIndex", int64(10)); uids != nil {
+		t.Errorf("expected no rows from a missing index, got %v", uids)
+	}
+}
+
+func TestNormalIndexDelete(t *testing.T) {
+	m := NewIndexManager()
+	NormalIndex{Value: "web1", UID: []string{"a", "b"}}.InsertOpIndexing(m, "deleteIndex")
+	NormalIndex{Value: "web1", UID: []string{"a"}}.DeleteOpIndexing(m, "deleteIndex")
+	if uids := m.LookupEqual("deleteIndex", "web1"); len(uids) != 1 || uids[0] != "b" {
+		t.Errorf("expected only row b left, got %v", uids)
+	}
+	NormalIndex{Value: "web1", UID: []string{"b"}}.DeleteOpIndexing(m, "deleteIndex")
+	if n := m.NormalIndexLen("deleteIndex"); n != 0 {
+		t.Errorf("empty value node should be removed, %d nodes left", n)
+	}
+}
+
+func TestConcurrentIndexing(t *testing.T) {
+	a, b := NewIndexManager(), NewIndexManager()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				uid := xid.New().String()
+				IDIndex(uid).InsertOpIndexing(a, "sameTable")
+				NormalIndex{Value: int64(g), UID: []string{uid}}.InsertOpIndexing(a, "sameIndex")
+				a.LookupEqual("sameIndex", int64(g))
+				IDIndex(uid).InsertOpIndexing(b, "sameTable")
+			}
+		}(g)
+	}
+	wg.Wait()
+	if n := a.IDIndexLen("sameTable"); n != 8000 {
+		t.Errorf("expected 8000 row IDs, got %d", n)
+	}
+	if uids := a.LookupRange("sameIndex", nil, nil); len(uids) != 8000 {
+		t.Errorf("expected 8000 indexed rows, got %d", len(uids))
+	}
+	b.DropTableIndexes("sameTable")
+	if a.IDIndexLen("sameTable") != 8000 || b.IDIndexLen("sameTable") != -1 {
+		t.Error("index managers of different databases should not share trees")
+	}
+}
+
 func tTraverse(buf *bytes.Buffer) btree.ItemIterator {
 	return func(i btree.Item) bool {
 		buf.WriteString(string(i.(IDIndex)))
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"sync"
+
+	"github.com/google/btree"
+)
+
+// IndexManager 一个数据库的全部内存索引，由打开的数据库持有，可以被多个goroutine并发使用
+// 写操作持有写锁，查找和序列化持有读锁，不同数据库的索引互不影响
+type IndexManager struct {
+	mu sync.RWMutex
+	// ids 表ID -> row key ID的索引
+	ids map[string]*btree.BTree
+	// normal 索引ID -> 普通字段的索引
+	normal map[string]*btree.BTree
+}
+
+// NewIndexManager 新建一个空的索引管理器
+func NewIndexManager() *IndexManager {
+	return &IndexManager{
+		ids:    make(map[string]*btree.BTree, 10),
+		normal: make(map[string]*btree.BTree, 10),
+	}
+}
+
+// ReplaceTableIndexes 用重建好的索引树整体替换一张表的ID索引和普通字段索引（索引ID->索引树）
+func (m *IndexManager) ReplaceTableIndexes(tableID string, idTree *btree.BTree, normal map[string]*btree.BTree) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ids[tableID] = idTree
+	for indexID, tree := range normal {
+		m.normal[indexID] = tree
+	}
+}
+
+// DropTableIndexes 移除一张表的ID索引和给定的普通字段索引
+func (m *IndexManager) DropTableIndexes(tableID string, indexIDs ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.ids, tableID)
+	for _, indexID := range indexIDs {
+		delete(m.normal, indexID)
+	}
+}
+
+// IDIndexLen 返回表的ID索引中的行数，索引不存在时返回-1
+func (m *IndexManager) IDIndexLen(tableID string) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tree, ok := m.ids[tableID]
+	if !ok {
+		return -1
+	}
+	return tree.Len()
+}
+
+// NormalIndexLen 返回普通字段索引中不同值的个数，索引不存在时返回-1
+func (m *IndexManager) NormalIndexLen(indexID string) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tree, ok := m.normal[indexID]
+	if !ok {
+		return -1
+	}
+	return tree.Len()
+}
+
+// LookupEqual 在普通字段索引中查找字段值等于value的行ID
+func (m *IndexManager) LookupEqual(indexID string, value interface{}) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tree, ok := m.normal[indexID]
+	if !ok {
+		return nil
+	}
+	item := tree.Get(NormalIndex{Value: value})
+	if item == nil {
+		return nil
+	}
+	uids := item.(NormalIndex).UID
+	out := make([]string, len(uids))
+	copy(out, uids)
+	return out
+}
+
+// LookupRange 在普通字段索引中查找字段值在 [lower, upper) 区间内的行ID，结果按字段值升序排列
+// lower或upper为nil表示该端不设限制
+func (m *IndexManager) LookupRange(indexID string, lower, upper interface{}) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tree, ok := m.normal[indexID]
+	if !ok {
+		return nil
+	}
+	var out []string
+	collect := func(i btree.Item) bool {
+		out = append(out, i.(NormalIndex).UID...)
+		return true
+	}
+	switch {
+	case lower != nil && upper != nil:
+		tree.AscendRange(NormalIndex{Value: lower}, NormalIndex{Value: upper}, collect)
+	case lower != nil:
+		tree.AscendGreaterOrEqual(NormalIndex{Value: lower}, collect)
+	case upper != nil:
+		tree.AscendLessThan(NormalIndex{Value: upper}, collect)
+	default:
+		tree.Ascend(collect)
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// 索引快照格式: 魔数"B2IX"(4字节) + 版本(1字节) + 索引类型(1字节) + CRC32C校验和(4字节，大端) + 序列化后的索引
+
+const (
+	snapshotMagic   = "B2IX"
+	snapshotVersion = byte(1)
+	snapshotHeader  = len(snapshotMagic) + 1 + 1 + 4
+)
+
+// 索引快照类型
+const (
+	SnapshotIDIndex     = byte(iota + 1) // ID索引
+	SnapshotNormalIndex                  // 普通字段索引
+)
+
+// ErrCorruptSnapshot 索引快照校验失败
+var ErrCorruptSnapshot = errors.New("corrupt index snapshot")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// IDIndexSnapshot 生成某张表ID索引的快照，表没有ID索引时返回nil
+func (m *IndexManager) IDIndexSnapshot(tableID string) ([]byte, error) {
+	treeBytes, err := IDIndex(nil).Serialize(m, tableID)
+	if err == errTableNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return encodeSnapshot(SnapshotIDIndex, treeBytes), nil
+}
+
+// NormalIndexSnapshot 生成某个普通字段索引的快照，索引不存在时返回nil
+func (m *IndexManager) NormalIndexSnapshot(indexID string) ([]byte, error) {
+	treeBytes, err := NormalIndex{}.Serialize(m, indexID)
+	if err == errIndexNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return encodeSnapshot(SnapshotNormalIndex, treeBytes), nil
+}
+
+// LoadSnapshot 校验并还原一个索引快照，id为表ID（ID索引）或索引ID（普通字段索引）
+func (m *IndexManager) LoadSnapshot(id string, data []byte) error {
+	kind, treeBytes, err := decodeSnapshot(data)
+	if err != nil {
+		return err
+	}
+	tree := NewIndexTree()
+	switch kind {
+	case SnapshotIDIndex:
+		if len(treeBytes) > 0 {
+			if tree, err = IdIndexDeserialize(treeBytes); err != nil {
+				return ErrCorruptSnapshot
+			}
+		}
+		m.mu.Lock()
+		m.ids[id] = tree
+		m.mu.Unlock()
+	case SnapshotNormalIndex:
+		if len(treeBytes) > 0 {
+			if tree, err = NormalIndexDeserialize(treeBytes); err != nil {
+				return ErrCorruptSnapshot
+			}
+		}
+		m.mu.Lock()
+		m.normal[id] = tree
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// encodeSnapshot 为序列化后的索引加上快照头
+func encodeSnapshot(kind byte, treeBytes []byte) []byte {
+	out := make([]byte, snapshotHeader, snapshotHeader+len(treeBytes))
+	copy(out, snapshotMagic)
+	out[4] = snapshotVersion
+	out[5] = kind
+	binary.BigEndian.PutUint32(out[6:], crc32.Checksum(treeBytes, castagnoli))
+	return append(out, treeBytes...)
+}
+
+// decodeSnapshot 校验快照头和校验和，返回索引类型和序列化后的索引
+func decodeSnapshot(data []byte) (byte, []byte, error) {
+	if len(data) < snapshotHeader || string(data[:4]) != snapshotMagic {
+		return 0, nil, ErrCorruptSnapshot
+	}
+	if data[4] != snapshotVersion {
+		return 0, nil, errors.New("unsupported index snapshot version")
+	}
+	kind := data[5]
+	if kind != SnapshotIDIndex && kind != SnapshotNormalIndex {
+		return 0, nil, ErrCorruptSnapshot
+	}
+	treeBytes := data[snapshotHeader:]
+	if binary.BigEndian.Uint32(data[6:]) != crc32.Checksum(treeBytes, castagnoli) {
+		return 0, nil, ErrCorruptSnapshot
+	}
+	return kind, treeBytes, nil
+}
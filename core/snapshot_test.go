@@ -0,0 +1,53 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	m := NewIndexManager()
+	ts := time.Date(2019, 1, 1, 0, 0, 0, 1, time.UTC)
+	for _, v := range []interface{}{int32(7), int64(-7), float32(1.5), 2.5, "web1", []byte{0, 1}, ts} {
+		indexID := "snapshotIndex"
+		m.DropTableIndexes("", indexID)
+		NormalIndex{Value: v, UID: []string{"a", "b"}}.InsertOpIndexing(m, indexID)
+		data, err := m.NormalIndexSnapshot(indexID)
+		if err != nil {
+			t.Fatalf("snapshot of %T index failed: %v", v, err)
+		}
+		m.DropTableIndexes("", indexID)
+		if err = m.LoadSnapshot(indexID, data); err != nil {
+			t.Fatalf("loading %T index snapshot failed: %v", v, err)
+		}
+		if uids := m.LookupEqual(indexID, v); len(uids) != 2 {
+			t.Errorf("restored %T index lost row IDs: %v", v, uids)
+		}
+	}
+	IDIndex("row1").InsertOpIndexing(m, "snapshotTable")
+	IDIndex("row2").InsertOpIndexing(m, "snapshotTable")
+	data, err := m.IDIndexSnapshot("snapshotTable")
+	if err != nil {
+		t.Fatalf("snapshot of ID index failed: %v", err)
+	}
+	m.DropTableIndexes("snapshotTable")
+	if err = m.LoadSnapshot("snapshotTable", data); err != nil || m.IDIndexLen("snapshotTable") != 2 {
+		t.Errorf("restoring ID index failed: %v", err)
+	}
+	if data, _ = m.IDIndexSnapshot("noSuchTable"); data != nil {
+		t.Error("snapshot of a missing index should be nil")
+	}
+}
+
+func TestCorruptSnapshot(t *testing.T) {
+	m := NewIndexManager()
+	NormalIndex{Value: "web1", UID: []string{"a"}}.InsertOpIndexing(m, "corruptIndex")
+	data, _ := m.NormalIndexSnapshot("corruptIndex")
+	data[len(data)-1] ^= 0xFF
+	if err := m.LoadSnapshot("corruptIndex", data); err != ErrCorruptSnapshot {
+		t.Errorf("expected ErrCorruptSnapshot for flipped payload, got %v", err)
+	}
+	if err := m.LoadSnapshot("corruptIndex", []byte("garbage")); err != ErrCorruptSnapshot {
+		t.Errorf("expected ErrCorruptSnapshot for garbage, got %v", err)
+	}
+}